@@ -0,0 +1,254 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format of the entries written by AccessLog.
+type AccessLogFormat int
+
+const (
+	// AccessLogSlog logs entries as attributes of a log/slog record.
+	AccessLogSlog AccessLogFormat = iota
+	// AccessLogCombined writes entries in the Apache combined log format.
+	AccessLogCombined
+	// AccessLogJSON writes entries as JSON objects, one per line.
+	AccessLogJSON
+)
+
+// AccessLogEntry describes a served request.
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration_ns"`
+	Remote    string        `json:"remote"`
+	User      string        `json:"user,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type requestErrorKey struct{}
+
+type requestError struct {
+	mu  sync.Mutex
+	err error
+}
+
+// RecordRequestError records err as the error of the request so that it is
+// included in AccessLog entries. WriteCodedError records the errors it
+// renders; handlers may record other errors themselves.
+func RecordRequestError(req *http.Request, err error) {
+	if holder, ok := req.Context().Value(requestErrorKey{}).(*requestError); ok {
+		holder.mu.Lock()
+		holder.err = err
+		holder.mu.Unlock()
+	}
+}
+
+// AccessLog is a middleware that logs every request with its response's
+// status code, body size, latency and recorded error. Requests whose
+// handler panics are logged with the panic as their error and, unless a
+// response was already started, a 500 status code as RecoverMiddleware
+// sends; the panic then carries on.
+// Sample usage is:
+//
+//	handler := otils.AccessLogMiddleware(&otils.AccessLog{
+//		Format:     otils.AccessLogCombined,
+//		SampleRate: 0.1,
+//		Exclude:    otils.MatchPathPrefix("/healthz"),
+//	}, mux)
+//
+// The ResponseWriter passed on to the next handler keeps implementing
+// http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom whenever
+// the original ResponseWriter does.
+type AccessLog struct {
+	Format AccessLogFormat
+
+	// Logger is the logger used by AccessLogSlog,
+	// if nil slog.Default() is used.
+	Logger *slog.Logger
+
+	// Output is where AccessLogCombined and AccessLogJSON
+	// entries are written, if nil os.Stderr is used.
+	Output io.Writer
+
+	// SampleRate if between 0 and 1 is the fraction of requests that
+	// are logged. Responses with 5XX status codes are always logged.
+	SampleRate float64
+
+	// Exclude if set matches requests that are never logged,
+	// for example health checks.
+	Exclude RequestMatcher
+
+	// ClientIP if set logs the client's address as determined by a
+	// ClientIPResolver rather than the address the request came from.
+	ClientIP *ClientIPResolver
+
+	next http.Handler
+	mu   *sync.Mutex
+}
+
+// AccessLogMiddleware returns a handler that logs requests passed to next.
+// A nil AccessLog uses the default settings.
+func AccessLogMiddleware(al *AccessLog, next http.Handler) http.Handler {
+	copy := new(AccessLog)
+	if al != nil {
+		*copy = *al
+	}
+	copy.next = next
+	copy.mu = new(sync.Mutex)
+	return copy
+}
+
+var _ http.Handler = (*AccessLog)(nil)
+
+func (al *AccessLog) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if al.next == nil {
+		return
+	}
+	if al.Exclude != nil && al.Exclude(req) {
+		al.next.ServeHTTP(rw, req)
+		return
+	}
+
+	start := time.Now()
+	holder := new(requestError)
+	req = req.WithContext(context.WithValue(req.Context(), requestErrorKey{}, holder))
+	wrapped, w := wrapResponseWriter(rw)
+	defer func() {
+		// A panicking handler is logged with the response that
+		// RecoverMiddleware sends, before the panic carries on.
+		recovered := recover()
+		if recovered != nil {
+			defer panic(recovered)
+		}
+		status := w.status
+		if recovered != nil && !w.wroteHeader {
+			status = http.StatusInternalServerError
+		}
+		if status < 500 && al.SampleRate > 0 && al.SampleRate < 1 && rand.Float64() >= al.SampleRate {
+			return
+		}
+		entry := &AccessLogEntry{
+			Time:      start,
+			Method:    req.Method,
+			URI:       req.RequestURI,
+			Proto:     req.Proto,
+			Status:    status,
+			Bytes:     w.bytes,
+			Duration:  time.Since(start),
+			Remote:    al.ClientIP.ClientIP(req),
+			Referer:   req.Referer(),
+			UserAgent: req.UserAgent(),
+			RequestID: RequestIDFromContext(req.Context()),
+		}
+		if entry.URI == "" {
+			entry.URI = req.URL.RequestURI()
+		}
+		if user, _, ok := req.BasicAuth(); ok {
+			entry.User = user
+		}
+		holder.mu.Lock()
+		if holder.err != nil {
+			entry.Error = holder.err.Error()
+		}
+		holder.mu.Unlock()
+		if recovered != nil {
+			entry.Error = fmt.Sprintf("panic: %v", recovered)
+		}
+		al.log(req.Context(), entry)
+	}()
+	al.next.ServeHTTP(wrapped, req)
+}
+
+func (al *AccessLog) log(ctx context.Context, entry *AccessLogEntry) {
+	switch al.Format {
+	case AccessLogCombined:
+		al.write([]byte(entry.Combined() + "\n"))
+	case AccessLogJSON:
+		blob, _ := json.Marshal(entry)
+		al.write(append(blob, '\n'))
+	default:
+		logger := al.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		level := slog.LevelInfo
+		if entry.Status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", entry.Method),
+			slog.String("uri", entry.URI),
+			slog.Int("status", entry.Status),
+			slog.Int64("bytes", entry.Bytes),
+			slog.Duration("duration", entry.Duration),
+			slog.String("remote", entry.Remote),
+			slog.String("user_agent", entry.UserAgent),
+		}
+		if entry.RequestID != "" {
+			attrs = append(attrs, slog.String("request_id", entry.RequestID))
+		}
+		if entry.Error != "" {
+			attrs = append(attrs, slog.String("error", entry.Error))
+		}
+		logger.LogAttrs(ctx, level, "http request", attrs...)
+	}
+}
+
+func (al *AccessLog) write(line []byte) {
+	out := al.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	_, _ = out.Write(line)
+}
+
+// Combined formats the entry in the Apache combined log format:
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08"
+func (e *AccessLogEntry) Combined() string {
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s",
+		orDash(e.Remote), orDash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.URI+" "+e.Proto), e.Status, size,
+		strconv.Quote(e.Referer), strconv.Quote(e.UserAgent))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// remoteHost returns the host part of the request's remote address.
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
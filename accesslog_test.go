@@ -0,0 +1,189 @@
+package otils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogJSON(t *testing.T) {
+	handler := func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			WriteCodedError(rw, req, MakeCodedError("no such page", http.StatusNotFound))
+		default:
+			_, _ = rw.Write([]byte("hello"))
+		}
+	}
+
+	tests := []struct {
+		path      string
+		wantLog   bool
+		wantEntry AccessLogEntry
+	}{
+		{path: "/", wantLog: true, wantEntry: AccessLogEntry{Method: "GET", URI: "/", Status: 200, Bytes: 5}},
+		{path: "/missing", wantLog: true, wantEntry: AccessLogEntry{Method: "GET", URI: "/missing", Status: 404, Error: "no such page"}},
+		{path: "/healthz", wantLog: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			al := AccessLogMiddleware(&AccessLog{
+				Format:  AccessLogJSON,
+				Output:  out,
+				Exclude: MatchPathPrefix("/healthz"),
+			}, http.HandlerFunc(handler))
+			al.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if !tt.wantLog {
+				if out.Len() != 0 {
+					t.Fatalf("expected no log, got %s", out)
+				}
+				return
+			}
+			var got AccessLogEntry
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode %q: %v", out, err)
+			}
+			if got.Method != tt.wantEntry.Method || got.URI != tt.wantEntry.URI || got.Status != tt.wantEntry.Status || got.Error != tt.wantEntry.Error {
+				t.Fatalf("got=%+v want=%+v", got, tt.wantEntry)
+			}
+			if tt.wantEntry.Bytes != 0 && got.Bytes != tt.wantEntry.Bytes {
+				t.Fatalf("gotBytes=%d wantBytes=%d", got.Bytes, tt.wantEntry.Bytes)
+			}
+		})
+	}
+}
+
+func TestAccessLogPanic(t *testing.T) {
+	out := new(bytes.Buffer)
+	handler := RecoverMiddleware(&Recoverer{OnPanic: func(*http.Request, interface{}, []byte) {}},
+		AccessLogMiddleware(&AccessLog{Format: AccessLogJSON, Output: out}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var got AccessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %q: %v", out, err)
+	}
+	if got.Status != rec.Code || got.Status != http.StatusInternalServerError {
+		t.Fatalf("gotStatus=%d responseStatus=%d wantStatus=%d", got.Status, rec.Code, http.StatusInternalServerError)
+	}
+	if want := "panic: boom"; got.Error != want {
+		t.Fatalf("gotError=%q wantError=%q", got.Error, want)
+	}
+}
+
+func TestAccessLogClientIP(t *testing.T) {
+	trusted, _ := ParseCIDRs("10.0.0.0/8")
+	out := new(bytes.Buffer)
+	al := AccessLogMiddleware(&AccessLog{
+		Format:   AccessLogJSON,
+		Output:   out,
+		ClientIP: &ClientIPResolver{TrustedProxies: trusted},
+	}, http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	al.ServeHTTP(httptest.NewRecorder(), req)
+
+	var got AccessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %q: %v", out, err)
+	}
+	if got.Remote != "198.51.100.1" {
+		t.Fatalf("got=%q want=%q", got.Remote, "198.51.100.1")
+	}
+}
+
+func TestAccessLogSlog(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	handler := RequestIDMiddleware(&RequestID{Generate: func() string { return "req-1" }},
+		AccessLogMiddleware(&AccessLog{Logger: logger}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusBadGateway)
+		})))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/upload", nil))
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %q: %v", out, err)
+	}
+	if got["level"] != "ERROR" || got["status"] != float64(502) || got["request_id"] != "req-1" || got["method"] != "POST" {
+		t.Fatalf("unexpected record: %s", out)
+	}
+}
+
+func TestAccessLogEntryCombined(t *testing.T) {
+	entry := &AccessLogEntry{
+		Time:      time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+		Method:    "GET",
+		URI:       "/apache_pb.gif",
+		Proto:     "HTTP/1.0",
+		Status:    200,
+		Bytes:     2326,
+		Remote:    "127.0.0.1",
+		User:      "frank",
+		Referer:   "http://www.example.com/start.html",
+		UserAgent: "Mozilla/4.08",
+	}
+	want := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`
+	if got := entry.Combined(); got != want {
+		t.Fatalf("\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	check := func(rw http.ResponseWriter) string {
+		var caps []string
+		if _, ok := rw.(http.Flusher); ok {
+			caps = append(caps, "flusher")
+		}
+		if _, ok := rw.(http.Hijacker); ok {
+			caps = append(caps, "hijacker")
+		}
+		if _, ok := rw.(http.Pusher); ok {
+			caps = append(caps, "pusher")
+		}
+		if _, ok := rw.(io.ReaderFrom); ok {
+			caps = append(caps, "readerfrom")
+		}
+		return strings.Join(caps, ",")
+	}
+
+	var want, got string
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		want = check(rw)
+		wrapped, w := wrapResponseWriter(rw)
+		got = check(wrapped)
+		n, _ := wrapped.(io.ReaderFrom).ReadFrom(strings.NewReader("streamed"))
+		if n != w.bytes {
+			t.Errorf("ReadFrom wrote %d bytes but %d were recorded", n, w.bytes)
+		}
+	}))
+	defer tst.Close()
+
+	res, err := tst.Client().Get(tst.URL)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	res.Body.Close()
+	if got != want || want != "flusher,hijacker,readerfrom" {
+		t.Fatalf("gotInterfaces=%q wantInterfaces=%q", got, want)
+	}
+
+	recorded, _ := wrapResponseWriter(httptest.NewRecorder())
+	if got := check(recorded); got != "flusher" {
+		t.Fatalf("gotInterfaces=%q wantInterfaces=%q", got, "flusher")
+	}
+}
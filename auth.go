@@ -0,0 +1,365 @@
+package otils
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Principal is the identity that a request was authenticated as.
+type Principal struct {
+	// Name identifies the user, client or key.
+	Name string
+
+	// Scheme is the authentication scheme used, e.g. "Basic".
+	Scheme string
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx that carries p.
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal set by AuthMiddleware, if any.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// ErrNoCredentials is returned by Authenticators for requests that do
+// not carry the credentials they handle, so that others can be tried.
+var ErrNoCredentials = errors.New("otils: no credentials")
+
+// Authenticator authenticates requests with one scheme.
+type Authenticator interface {
+	// Authenticate returns the principal that sent req, ErrNoCredentials
+	// if req has no credentials for this scheme, or another error if they
+	// are invalid. A returned *CodedError is sent to the client as is.
+	Authenticate(req *http.Request) (*Principal, error)
+
+	// Challenge returns the WWW-Authenticate header value asking for
+	// credentials, err being the error returned by Authenticate.
+	Challenge(err error) string
+}
+
+// VerifyPasswordHash returns nil if password matches hash, comparing them
+// in constant time. The hash is either a bcrypt hash like "$2a$10$..." or
+// an argon2id or argon2i hash in the PHC format, like
+// "$argon2id$v=19$m=65536,t=3,p=4$salt$key".
+func VerifyPasswordHash(hash, password string) error {
+	if strings.HasPrefix(hash, "$argon2") {
+		return verifyArgon2(hash, password)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+var errPasswordMismatch = errors.New("otils: password does not match hash")
+
+func verifyArgon2(hash, password string) error {
+	// ["", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key]
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return errors.New("otils: malformed argon2 hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return fmt.Errorf("otils: unsupported argon2 version %q", parts[2])
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return fmt.Errorf("otils: malformed argon2 parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return fmt.Errorf("otils: malformed argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return fmt.Errorf("otils: malformed argon2 key: %w", err)
+	}
+
+	var derived []byte
+	switch parts[1] {
+	case "argon2id":
+		derived = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	case "argon2i":
+		derived = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	default:
+		return fmt.Errorf("otils: unsupported argon2 variant %q", parts[1])
+	}
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
+// dummyBcryptHash is compared against for unknown users so that
+// response times do not reveal which users exist.
+var dummyBcryptHash = []byte("$2a$10$RSLUr.9yznZ5.ryLTmQ3zu1t93HoG1syfisTM8I4rnDAxTh4LO5HG")
+
+// BasicAuth authenticates requests with HTTP Basic authentication
+// against password hashes, see VerifyPasswordHash.
+type BasicAuth struct {
+	Realm string
+
+	// Users maps user names to their password hashes.
+	Users map[string]string
+
+	// Lookup if set returns the password hash of a user, with an
+	// empty hash signifying that the user does not exist.
+	Lookup func(ctx context.Context, user string) (hash string, err error)
+}
+
+var _ Authenticator = (*BasicAuth)(nil)
+
+func (ba *BasicAuth) Authenticate(req *http.Request) (*Principal, error) {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	hash := ba.Users[user]
+	if ba.Lookup != nil {
+		var err error
+		if hash, err = ba.Lookup(req.Context(), user); err != nil {
+			return nil, err
+		}
+	}
+	if hash == "" {
+		_ = bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(password))
+		return nil, errPasswordMismatch
+	}
+	if err := VerifyPasswordHash(hash, password); err != nil {
+		return nil, err
+	}
+	return &Principal{Name: user, Scheme: "Basic"}, nil
+}
+
+func (ba *BasicAuth) Challenge(err error) string {
+	return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", ba.Realm)
+}
+
+// BearerAuth authenticates requests with bearer tokens, RFC 6750.
+type BearerAuth struct {
+	Realm string
+
+	// Validate returns the principal that token was issued to,
+	// or an error if it is invalid.
+	Validate func(ctx context.Context, token string) (*Principal, error)
+}
+
+var _ Authenticator = (*BearerAuth)(nil)
+
+func (ba *BearerAuth) Authenticate(req *http.Request) (*Principal, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	p, err := ba.Validate(req.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	if p.Scheme == "" {
+		p.Scheme = "Bearer"
+	}
+	return p, nil
+}
+
+func (ba *BearerAuth) Challenge(err error) string {
+	if err == nil || errors.Is(err, ErrNoCredentials) {
+		return fmt.Sprintf("Bearer realm=%q", ba.Realm)
+	}
+	return fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\"", ba.Realm)
+}
+
+// bearerToken returns the token in the Authorization header of req.
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// APIKeyAuth authenticates requests with API keys sent in a header or,
+// less safely since URLs end up in logs, a query parameter.
+type APIKeyAuth struct {
+	// Header carrying the key, it defaults to "X-API-Key".
+	Header string
+
+	// QueryParam if set is a query parameter that may carry the key.
+	QueryParam string
+
+	// Keys maps API keys to the name of their principal.
+	Keys map[string]string
+
+	// Lookup if set returns the principal that key was issued to, with
+	// a nil principal signifying that the key does not exist.
+	Lookup func(ctx context.Context, key string) (*Principal, error)
+}
+
+var _ Authenticator = (*APIKeyAuth)(nil)
+
+func (ak *APIKeyAuth) header() string {
+	if ak.Header == "" {
+		return "X-API-Key"
+	}
+	return ak.Header
+}
+
+func (ak *APIKeyAuth) Authenticate(req *http.Request) (*Principal, error) {
+	key := req.Header.Get(ak.header())
+	if key == "" && ak.QueryParam != "" {
+		key = req.URL.Query().Get(ak.QueryParam)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	if ak.Lookup != nil {
+		p, err := ak.Lookup(req.Context(), key)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, errors.New("otils: unknown API key")
+		}
+		if p.Scheme == "" {
+			p.Scheme = "APIKey"
+		}
+		return p, nil
+	}
+
+	// Compare digests against every key so that neither the
+	// length nor the position of a match leaks through timing.
+	digest := sha256.Sum256([]byte(key))
+	var name string
+	found := 0
+	for candidate, candidateName := range ak.Keys {
+		candidateDigest := sha256.Sum256([]byte(candidate))
+		if subtle.ConstantTimeCompare(digest[:], candidateDigest[:]) == 1 {
+			name = candidateName
+			found = 1
+		}
+	}
+	if found != 1 {
+		return nil, errors.New("otils: unknown API key")
+	}
+	return &Principal{Name: name, Scheme: "APIKey"}, nil
+}
+
+func (ak *APIKeyAuth) Challenge(err error) string {
+	return fmt.Sprintf("APIKey header=%q", ak.header())
+}
+
+// Auth is a middleware that authenticates requests with the first of its
+// Authenticators for which they carry credentials, storing the Principal
+// in the request's context. Requests without valid credentials get a 401
+// *CodedError along with a WWW-Authenticate challenge per Authenticator.
+// CORS preflight requests, which never carry credentials, are let through.
+// Sample usage is:
+//
+//	handler := otils.AuthMiddleware(&otils.Auth{
+//		Authenticators: []otils.Authenticator{
+//			&otils.BasicAuth{Realm: "admin", Users: map[string]string{"ops": bcryptHash}},
+//			&otils.APIKeyAuth{Keys: map[string]string{apiKey: "billing-service"}},
+//		},
+//	}, mux)
+//
+// after which handlers retrieve the principal with PrincipalFromContext.
+type Auth struct {
+	Authenticators []Authenticator
+
+	// Optional lets requests without any credentials through
+	// unauthenticated, invalid credentials are still rejected.
+	Optional bool
+
+	// Exempt matches requests that are let through unauthenticated.
+	Exempt RequestMatcher
+
+	next http.Handler
+}
+
+// AuthMiddleware returns a handler that authenticates requests before
+// passing them on to next. A nil Auth lets all requests through.
+func AuthMiddleware(a *Auth, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	copy := new(Auth)
+	*copy = *a
+	copy.next = next
+	return copy
+}
+
+var _ http.Handler = (*Auth)(nil)
+
+func (a *Auth) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if isPreflight(req) || (a.Exempt != nil && a.Exempt(req)) {
+		a.serveNext(rw, req)
+		return
+	}
+
+	var err error = ErrNoCredentials
+	var failed Authenticator
+	for _, authenticator := range a.Authenticators {
+		var p *Principal
+		p, err = authenticator.Authenticate(req)
+		if err == nil {
+			a.serveNext(rw, req.WithContext(ContextWithPrincipal(req.Context(), p)))
+			return
+		}
+		if !errors.Is(err, ErrNoCredentials) {
+			failed = authenticator
+			break
+		}
+	}
+	if failed == nil && a.Optional {
+		a.serveNext(rw, req)
+		return
+	}
+
+	for _, authenticator := range a.Authenticators {
+		challengeErr := error(ErrNoCredentials)
+		if authenticator == failed {
+			challengeErr = err
+		}
+		if challenge := authenticator.Challenge(challengeErr); challenge != "" {
+			rw.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	var cerr *CodedError
+	switch {
+	case errors.As(err, &cerr):
+		WriteCodedError(rw, req, cerr)
+	case failed != nil:
+		WriteCodedError(rw, req, MakeCodedError("invalid credentials", http.StatusUnauthorized))
+	default:
+		WriteCodedError(rw, req, MakeCodedError("authentication required", http.StatusUnauthorized))
+	}
+}
+
+func (a *Auth) serveNext(rw http.ResponseWriter, req *http.Request) {
+	if a.next != nil {
+		a.next.ServeHTTP(rw, req)
+	}
+}
+
+// isPreflight reports whether req is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
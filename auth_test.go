@@ -0,0 +1,226 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const (
+	testBcryptHash = "$2a$04$FsJICKaf/AcpDLBwg7cfDOdpIugt8kHfLL2ogwLL1YfVbF42osKcW"
+	testArgon2Hash = "$argon2id$v=19$m=64,t=1,p=1$b3JpanRlY2gtc2FsdC0xNg$Etp6wrccSvCuVCc5k5J6+K2iOcFGXp5g8v/epqSw450"
+)
+
+func TestVerifyPasswordHash(t *testing.T) {
+	tests := []struct {
+		hash     string
+		password string
+		wantErr  bool
+	}{
+		0: {hash: testBcryptHash, password: "hunter2"},
+		1: {hash: testBcryptHash, password: "hunter3", wantErr: true},
+		2: {hash: testArgon2Hash, password: "hunter2"},
+		3: {hash: testArgon2Hash, password: "hunter3", wantErr: true},
+		4: {hash: "$argon2id$v=19$m=64,t=1,p=1$bad", password: "hunter2", wantErr: true},
+		5: {hash: "$argon2d$v=19$m=64,t=1,p=1$b3JpanRlY2gtc2FsdC0xNg$Etp6wrccSvCuVCc5k5J6", password: "hunter2", wantErr: true},
+		6: {hash: "hunter2", password: "hunter2", wantErr: true},
+	}
+
+	for i, tt := range tests {
+		err := VerifyPasswordHash(tt.hash, tt.password)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d gotErr=%v wantErr=%t", i, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	validate := func(ctx context.Context, token string) (*Principal, error) {
+		switch token {
+		case "good-token":
+			return &Principal{Name: "svc"}, nil
+		case "revoked-token":
+			return nil, MakeCodedError("token revoked", http.StatusForbidden)
+		}
+		return nil, errors.New("unknown token")
+	}
+	auth := &Auth{
+		Authenticators: []Authenticator{
+			&BasicAuth{Realm: "otils", Users: map[string]string{"ops": testBcryptHash, "dev": testArgon2Hash}},
+			&BearerAuth{Realm: "otils", Validate: validate},
+			&APIKeyAuth{QueryParam: "api_key", Keys: map[string]string{"k-123": "billing"}},
+		},
+		Exempt: MatchPathPrefix("/healthz"),
+	}
+
+	tests := []struct {
+		name          string
+		auth          *Auth
+		method        string
+		path          string
+		header        http.Header
+		setBasic      []string
+		wantCode      int
+		wantPrincipal *Principal
+		wantMsg       string
+		wantChallenge []string
+	}{
+		{
+			name:          "bcrypt basic",
+			setBasic:      []string{"ops", "hunter2"},
+			wantCode:      http.StatusOK,
+			wantPrincipal: &Principal{Name: "ops", Scheme: "Basic"},
+		},
+		{
+			name:          "argon2 basic",
+			setBasic:      []string{"dev", "hunter2"},
+			wantCode:      http.StatusOK,
+			wantPrincipal: &Principal{Name: "dev", Scheme: "Basic"},
+		},
+		{
+			name:     "wrong password",
+			setBasic: []string{"ops", "hunter3"},
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid credentials",
+			wantChallenge: []string{
+				`Basic realm="otils", charset="UTF-8"`,
+				`Bearer realm="otils"`,
+				`APIKey header="X-API-Key"`,
+			},
+		},
+		{
+			name:     "unknown user",
+			setBasic: []string{"root", "hunter2"},
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid credentials",
+		},
+		{
+			name:          "bearer",
+			header:        http.Header{"Authorization": {"Bearer good-token"}},
+			wantCode:      http.StatusOK,
+			wantPrincipal: &Principal{Name: "svc", Scheme: "Bearer"},
+		},
+		{
+			name:     "invalid bearer",
+			header:   http.Header{"Authorization": {"bearer bad-token"}},
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid credentials",
+			wantChallenge: []string{
+				`Basic realm="otils", charset="UTF-8"`,
+				`Bearer realm="otils", error="invalid_token"`,
+				`APIKey header="X-API-Key"`,
+			},
+		},
+		{
+			name:     "coded error from an authenticator",
+			header:   http.Header{"Authorization": {"Bearer revoked-token"}},
+			wantCode: http.StatusForbidden,
+			wantMsg:  "token revoked",
+		},
+		{
+			name:          "api key header",
+			header:        http.Header{"X-Api-Key": {"k-123"}},
+			wantCode:      http.StatusOK,
+			wantPrincipal: &Principal{Name: "billing", Scheme: "APIKey"},
+		},
+		{
+			name:          "api key query",
+			path:          "/?api_key=k-123",
+			wantCode:      http.StatusOK,
+			wantPrincipal: &Principal{Name: "billing", Scheme: "APIKey"},
+		},
+		{
+			name:     "unknown api key",
+			header:   http.Header{"X-Api-Key": {"k-1234"}},
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid credentials",
+		},
+		{
+			name:     "no credentials",
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "authentication required",
+			wantChallenge: []string{
+				`Basic realm="otils", charset="UTF-8"`,
+				`Bearer realm="otils"`,
+				`APIKey header="X-API-Key"`,
+			},
+		},
+		{
+			name:     "no credentials optional",
+			auth:     &Auth{Authenticators: auth.Authenticators, Optional: true},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "invalid credentials optional",
+			auth:     &Auth{Authenticators: auth.Authenticators, Optional: true},
+			header:   http.Header{"X-Api-Key": {"nope"}},
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid credentials",
+		},
+		{
+			name:     "exempt",
+			path:     "/healthz",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:   "cors preflight",
+			method: http.MethodOptions,
+			header: http.Header{
+				"Origin":                        {"https://orijtech.com"},
+				"Access-Control-Request-Method": {"PUT"},
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.auth
+			if a == nil {
+				a = auth
+			}
+			var got *Principal
+			handler := AuthMiddleware(a, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = PrincipalFromContext(req.Context())
+			}))
+
+			method, path := tt.method, tt.path
+			if method == "" {
+				method = http.MethodGet
+			}
+			if path == "" {
+				path = "/"
+			}
+			req := httptest.NewRequest(method, path, nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			if tt.setBasic != nil {
+				req.SetBasicAuth(tt.setBasic[0], tt.setBasic[1])
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK {
+				if !reflect.DeepEqual(got, tt.wantPrincipal) {
+					t.Fatalf("gotPrincipal=%+v wantPrincipal=%+v", got, tt.wantPrincipal)
+				}
+				return
+			}
+			var body codedErrorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != tt.wantMsg {
+				t.Fatalf("got=%q want=%q", rec.Body, tt.wantMsg)
+			}
+			if tt.wantChallenge != nil && !reflect.DeepEqual(rec.Header().Values("WWW-Authenticate"), tt.wantChallenge) {
+				t.Fatalf("gotChallenge=%q wantChallenge=%q", rec.Header().Values("WWW-Authenticate"), tt.wantChallenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package otils
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through while counting failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all requests fast until the open timeout elapses.
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial requests through to decide
+	// whether to close the circuit again or to reopen it.
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(cs))
+	}
+}
+
+// CircuitBreakerTransport is an http.RoundTripper that keeps a circuit
+// breaker per destination host. After FailureThreshold consecutive failures
+// the circuit opens and requests to that host fail fast with a 503
+// *CodedError, sparing an unhealthy upstream. Once OpenTimeout elapses
+// the circuit is half-open and lets HalfOpenRequests trial requests
+// through: a success closes it again while a failure reopens it.
+//
+// It composes with RateLimitTransport and RetryTransport, for example:
+//
+//	client := &http.Client{
+//		Transport: &otils.CircuitBreakerTransport{
+//			Base: &otils.RateLimitTransport{RequestsPerSecond: 5},
+//		},
+//	}
+type CircuitBreakerTransport struct {
+	// Base is the underlying transport, if nil http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// FailureThreshold is the number of consecutive failures
+	// that opens the circuit, it defaults to 5.
+	FailureThreshold int
+
+	// OpenTimeout is how long the circuit stays open
+	// before going half-open, it defaults to 30s.
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of concurrent trial requests
+	// allowed while half-open, it defaults to 1.
+	HalfOpenRequests int
+
+	// IsFailure if set decides whether a round trip counts as a failure,
+	// otherwise network errors and statuses for which RetryableStatus
+	// returns true are failures.
+	IsFailure func(res *http.Response, err error) bool
+
+	// OnStateChange if set is invoked whenever the circuit
+	// for a host changes state, for example to record metrics.
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+var _ http.RoundTripper = (*CircuitBreakerTransport)(nil)
+
+func (cbt *CircuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	cb := cbt.breaker(host)
+
+	ok, from, to := cb.allow(time.Now())
+	cbt.notify(host, from, to)
+	if !ok {
+		closeRequestBody(req)
+		return nil, MakeCodedError(fmt.Sprintf("circuit breaker is open for %q", host), http.StatusServiceUnavailable)
+	}
+
+	base := cbt.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	res, err := base.RoundTrip(req)
+
+	from, to = cb.record(cbt.isFailure(res, err), time.Now())
+	cbt.notify(host, from, to)
+	return res, err
+}
+
+// State returns the current state of the circuit for host.
+func (cbt *CircuitBreakerTransport) State(host string) CircuitState {
+	cb := cbt.breaker(host)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+func (cbt *CircuitBreakerTransport) breaker(host string) *circuitBreaker {
+	cbt.mu.Lock()
+	defer cbt.mu.Unlock()
+
+	if cbt.breakers == nil {
+		cbt.breakers = make(map[string]*circuitBreaker)
+	}
+	cb, ok := cbt.breakers[host]
+	if !ok {
+		cb = &circuitBreaker{
+			threshold:   cbt.FailureThreshold,
+			openTimeout: cbt.OpenTimeout,
+			maxTrials:   cbt.HalfOpenRequests,
+		}
+		if cb.threshold <= 0 {
+			cb.threshold = 5
+		}
+		if cb.openTimeout <= 0 {
+			cb.openTimeout = 30 * time.Second
+		}
+		if cb.maxTrials <= 0 {
+			cb.maxTrials = 1
+		}
+		cbt.breakers[host] = cb
+	}
+	return cb
+}
+
+func (cbt *CircuitBreakerTransport) isFailure(res *http.Response, err error) bool {
+	if cbt.IsFailure != nil {
+		return cbt.IsFailure(res, err)
+	}
+	return err != nil || RetryableStatus(res.StatusCode)
+}
+
+func (cbt *CircuitBreakerTransport) notify(host string, from, to CircuitState) {
+	if from != to && cbt.OnStateChange != nil {
+		cbt.OnStateChange(host, from, to)
+	}
+}
+
+type circuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+	maxTrials   int
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+// allow reports whether a request may proceed, along
+// with the state transition that deciding so caused.
+func (cb *circuitBreaker) allow(now time.Time) (ok bool, from, to CircuitState) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	from = cb.state
+	if cb.state == CircuitOpen && now.Sub(cb.openedAt) >= cb.openTimeout {
+		cb.state = CircuitHalfOpen
+		cb.trials = 0
+	}
+
+	switch cb.state {
+	case CircuitOpen:
+		return false, from, cb.state
+	case CircuitHalfOpen:
+		if cb.trials >= cb.maxTrials {
+			return false, from, cb.state
+		}
+		cb.trials++
+	}
+	return true, from, cb.state
+}
+
+// record updates the breaker with the outcome of a request
+// and returns the state transition that it caused.
+func (cb *circuitBreaker) record(failed bool, now time.Time) (from, to CircuitState) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	from = cb.state
+	switch {
+	case !failed:
+		cb.failures = 0
+		if cb.state == CircuitHalfOpen {
+			cb.state = CircuitClosed
+		}
+	case cb.state == CircuitHalfOpen:
+		cb.state = CircuitOpen
+		cb.openedAt = now
+	case cb.state == CircuitClosed:
+		cb.failures++
+		if cb.failures >= cb.threshold {
+			cb.state = CircuitOpen
+			cb.openedAt = now
+			cb.failures = 0
+		}
+	}
+	return from, cb.state
+}
@@ -0,0 +1,97 @@
+package otils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransport(t *testing.T) {
+	var healthy int32
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer tst.Close()
+
+	var transitions []string
+	cbt := &CircuitBreakerTransport{
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+		OnStateChange: func(host string, from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	}
+	client := &http.Client{Transport: cbt}
+
+	get := func() error {
+		res, err := client.Get(tst.URL)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	}
+
+	// Two upstream failures open the circuit.
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Fatalf("#%d: unexpected err: %v", i, err)
+		}
+	}
+
+	// While open, requests fail fast with a 503.
+	err := get()
+	cerr := new(CodedError)
+	if !errors.As(err, &cerr) || cerr.Code() != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 *CodedError, got: %v", err)
+	}
+	body := &closeTrackingBody{Reader: strings.NewReader("payload")}
+	req, _ := http.NewRequest(http.MethodPost, tst.URL, body)
+	if _, err := cbt.RoundTrip(req); err == nil || !body.closed {
+		t.Fatalf("expected the request to fail with its body closed, got err=%v closed=%t", err, body.closed)
+	}
+
+	// After the open timeout a successful trial closes the circuit.
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(30 * time.Millisecond)
+	if err := get(); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	host := tst.Listener.Addr().String()
+	if got := cbt.State(host); got != CircuitClosed {
+		t.Errorf("gotState=%s wantState=%s", got, CircuitClosed)
+	}
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("gotTransitions=%v wantTransitions=%v", transitions, want)
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	now := time.Now()
+	cb := &circuitBreaker{threshold: 1, openTimeout: time.Second, maxTrials: 1}
+
+	cb.record(true, now)
+	if ok, _, _ := cb.allow(now); ok {
+		t.Fatal("expected the open circuit to reject requests")
+	}
+
+	later := now.Add(time.Second)
+	if ok, _, to := cb.allow(later); !ok || to != CircuitHalfOpen {
+		t.Fatalf("expected a half-open trial, got ok=%t state=%s", ok, to)
+	}
+	if ok, _, _ := cb.allow(later); ok {
+		t.Fatal("expected only one concurrent half-open trial")
+	}
+	if _, to := cb.record(true, later); to != CircuitOpen {
+		t.Fatalf("gotState=%s wantState=%s", to, CircuitOpen)
+	}
+}
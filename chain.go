@@ -0,0 +1,172 @@
+package otils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Middleware wraps a handler to add behavior before or after it runs.
+type Middleware func(next http.Handler) http.Handler
+
+// MiddlewareFrom adapts a constructor in the style of CORSMiddleware, that
+// is one taking a configuration and the next handler, into a Middleware.
+// Sample usage is:
+//
+//	chain.Use("cors", otils.MiddlewareFrom(otils.CORSMiddleware, cors))
+func MiddlewareFrom[C any](constructor func(config C, next http.Handler) http.Handler, config C) Middleware {
+	return func(next http.Handler) http.Handler {
+		return constructor(config, next)
+	}
+}
+
+// RequestMatcher reports whether a request matches some condition.
+type RequestMatcher func(req *http.Request) bool
+
+// MatchPathPrefix matches requests whose path starts with any of prefixes.
+func MatchPathPrefix(prefixes ...string) RequestMatcher {
+	return func(req *http.Request) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchMethods matches requests using any of methods.
+func MatchMethods(methods ...string) RequestMatcher {
+	return func(req *http.Request) bool {
+		for _, method := range methods {
+			if strings.EqualFold(req.Method, method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not inverts matcher.
+func Not(matcher RequestMatcher) RequestMatcher {
+	return func(req *http.Request) bool { return !matcher(req) }
+}
+
+// Chain is an ordered list of named middlewares. The first middleware
+// added is the outermost one, so it sees requests first.
+// Sample usage is:
+//
+//	chain := new(otils.Chain).
+//		Use("cors", otils.MiddlewareFrom(otils.CORSMiddleware, cors)).
+//		UseIf("webhooks", otils.MatchPathPrefix("/webhooks/"), verify)
+//	log.Printf("middlewares: %s", chain)
+//	handler := chain.Then(mux)
+//
+// The zero value is an empty chain ready to use.
+type Chain struct {
+	links []chainLink
+}
+
+type chainLink struct {
+	name  string
+	mw    Middleware
+	match RequestMatcher
+}
+
+// NewChain returns a chain of the given middlewares, named after their
+// position; use Use to give them names that show up in String.
+func NewChain(mws ...Middleware) *Chain {
+	c := new(Chain)
+	for _, mw := range mws {
+		c.Use("", mw)
+	}
+	return c
+}
+
+// Use adds mw to the end of the chain under name and returns the chain.
+func (c *Chain) Use(name string, mw Middleware) *Chain {
+	return c.UseIf(name, nil, mw)
+}
+
+// UseIf adds mw to the end of the chain under name, but only applies it to
+// requests that match matches; other requests skip it. A nil matcher
+// matches all requests.
+func (c *Chain) UseIf(name string, matches RequestMatcher, mw Middleware) *Chain {
+	if mw == nil {
+		return c
+	}
+	c.links = append(c.links, chainLink{name: name, mw: mw, match: matches})
+	return c
+}
+
+// Append returns a new chain made of c's middlewares followed by
+// others', leaving both c and others unmodified.
+func (c *Chain) Append(others ...*Chain) *Chain {
+	appended := &Chain{links: append([]chainLink(nil), c.links...)}
+	for _, other := range others {
+		if other != nil {
+			appended.links = append(appended.links, other.links...)
+		}
+	}
+	return appended
+}
+
+// Then returns h wrapped by every middleware in the chain.
+// A nil h is treated as http.DefaultServeMux.
+func (c *Chain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c.links) - 1; i >= 0; i-- {
+		link := c.links[i]
+		wrapped := link.mw(h)
+		if link.match == nil {
+			h = wrapped
+			continue
+		}
+		h = conditionalHandler(link.match, wrapped, h)
+	}
+	return h
+}
+
+// ThenFunc is like Then but takes a handler function.
+func (c *Chain) ThenFunc(fn http.HandlerFunc) http.Handler {
+	if fn == nil {
+		return c.Then(nil)
+	}
+	return c.Then(fn)
+}
+
+func conditionalHandler(match RequestMatcher, wrapped, skip http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if match(req) {
+			wrapped.ServeHTTP(rw, req)
+		} else {
+			skip.ServeHTTP(rw, req)
+		}
+	})
+}
+
+// Names returns the names of the middlewares in the chain from
+// outermost to innermost. Conditional middlewares are suffixed with "?"
+// and unnamed ones are named after their position, e.g. "#2".
+func (c *Chain) Names() []string {
+	names := make([]string, 0, len(c.links))
+	for i, link := range c.links {
+		name := link.name
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+		if link.match != nil {
+			name += "?"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// String describes the chain in the order requests go through it,
+// for example "requestid -> cors -> webhooks? -> handler".
+func (c *Chain) String() string {
+	return strings.Join(append(c.Names(), "handler"), " -> ")
+}
@@ -0,0 +1,64 @@
+package otils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tagMiddleware appends tag to the X-Trail response header.
+func tagMiddleware(tag string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Add("X-Trail", tag)
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := new(Chain).
+		Use("first", tagMiddleware("first")).
+		Use("cors", MiddlewareFrom(CORSMiddleware, &CORS{Origins: []string{"https://orijtech.com"}})).
+		UseIf("admin", MatchPathPrefix("/admin/"), tagMiddleware("admin")).
+		UseIf("writes", MatchMethods(http.MethodPost, http.MethodPut), tagMiddleware("writes"))
+	extended := chain.Append(NewChain(tagMiddleware("last")))
+
+	if got, want := extended.String(), "first -> cors -> admin? -> writes? -> #4 -> handler"; got != want {
+		t.Errorf("gotString=%q wantString=%q", got, want)
+	}
+	if got, want := len(chain.Names()), 4; got != want {
+		t.Errorf("Append modified the original chain: got %d middlewares want %d", got, want)
+	}
+
+	tests := []struct {
+		method    string
+		path      string
+		wantTrail []string
+	}{
+		{http.MethodGet, "/", []string{"first", "last"}},
+		{http.MethodGet, "/admin/users", []string{"first", "admin", "last"}},
+		{http.MethodPost, "/admin/users", []string{"first", "admin", "writes", "last"}},
+		{http.MethodPut, "/", []string{"first", "writes", "last"}},
+	}
+
+	handler := extended.ThenFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Add("X-Trail", "handler")
+	})
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			want := append(tt.wantTrail, "handler")
+			if got := rec.Header()["X-Trail"]; !reflect.DeepEqual(got, want) {
+				t.Errorf("gotTrail=%s wantTrail=%s", strings.Join(got, ","), strings.Join(want, ","))
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://orijtech.com" {
+				t.Errorf("CORS headers were not set, got origin=%q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package otils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxResponseBytes is the maximum number of bytes that a Client
+// reads from a response body if Client.MaxResponseBytes is unset.
+const DefaultMaxResponseBytes = 10 << 20
+
+// Client is a small helper for talking to JSON APIs. It composes
+// ToURLValues to build query strings, StatusOK to check responses and
+// converts non-2XX responses into *CodedError values.
+// Sample usage is:
+//
+//	client := &otils.Client{BaseURL: "https://api.orijtech.com/v1"}
+//	var page Page
+//	err := client.GetJSON(ctx, "/items", &Query{Page: 2}, &page)
+//
+// Requests made with a context carrying a request ID, such as the context
+// of a request handled by RequestIDMiddleware, propagate that ID in the
+// RequestIDHeader header.
+//
+// A Client is safe for concurrent use provided its fields are not
+// modified after first use.
+type Client struct {
+	// BaseURL is prefixed to every path passed to the request methods.
+	BaseURL string
+
+	// Header contains headers that are added to every outgoing request.
+	Header http.Header
+
+	// Auth if set is invoked with every outgoing request before it
+	// is sent, so that credentials can be attached to it.
+	Auth func(req *http.Request) error
+
+	// MaxResponseBytes caps how many bytes of a response body are read.
+	// If zero, DefaultMaxResponseBytes is used; if negative, there is no cap.
+	MaxResponseBytes int64
+
+	// HTTPClient is the client used to send requests, if nil
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+var errResponseTooLarge = errors.New("response body exceeds the maximum allowed size")
+
+// GetJSON sends a GET request to path, with query if non-nil encoded
+// by ToURLValues, and decodes the JSON response into out if non-nil.
+func (c *Client) GetJSON(ctx context.Context, path string, query, out interface{}) error {
+	return c.DoJSON(ctx, http.MethodGet, path, query, nil, out)
+}
+
+// PostJSON sends in as the JSON body of a POST request to path and
+// decodes the JSON response into out if non-nil.
+func (c *Client) PostJSON(ctx context.Context, path string, in, out interface{}) error {
+	return c.DoJSON(ctx, http.MethodPost, path, nil, in, out)
+}
+
+// DoJSON sends a request with the given method to path. query if non-nil is
+// transformed by ToURLValues, unless it already is a url.Values, and in if
+// non-nil is sent as the JSON body. A path that is an absolute URL is used
+// as is instead of being resolved against BaseURL.
+// A response whose status fails StatusOK is returned as a *CodedError
+// carrying the response's status code, otherwise the body is decoded into out.
+func (c *Client) DoJSON(ctx context.Context, method, path string, query, in, out interface{}) error {
+	_, blob, err := c.do(ctx, method, path, query, in)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(bytes.TrimSpace(blob)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(blob, out); err != nil {
+		return fmt.Errorf("otils: decoding response from %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// do sends the request and returns the headers and body of a
+// successful response, or a *CodedError for a failed one.
+func (c *Client) do(ctx context.Context, method, path string, query, in interface{}) (*http.Response, []byte, error) {
+	req, err := c.newRequest(ctx, method, path, query, in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	blob, err := c.readBody(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !StatusOK(res.StatusCode) {
+		return nil, nil, codedErrorFromResponse(res, blob)
+	}
+	return res, blob, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query, in interface{}) (*http.Request, error) {
+	fullURL, err := c.resolveURL(path, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if in != nil {
+		blob, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(blob)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if id := RequestIDFromContext(ctx); id != "" && req.Header.Get(RequestIDHeader) == "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if c.Auth != nil {
+		if err := c.Auth(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func (c *Client) resolveURL(path string, query interface{}) (string, error) {
+	fullURL := path
+	if c.BaseURL != "" && !isAbsoluteURL(path) {
+		fullURL = strings.TrimRight(c.BaseURL, "/") + "/" + strings.TrimLeft(path, "/")
+	}
+	if query == nil {
+		return fullURL, nil
+	}
+
+	values, ok := query.(url.Values)
+	if !ok {
+		var err error
+		if values, err = ToURLValues(query); err != nil {
+			return "", err
+		}
+	}
+	if len(values) == 0 {
+		return fullURL, nil
+	}
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return "", err
+	}
+	merged := u.Query()
+	for key, vals := range values {
+		merged[key] = append(merged[key], vals...)
+	}
+	u.RawQuery = merged.Encode()
+	return u.String(), nil
+}
+
+func isAbsoluteURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) readBody(rc io.Reader) ([]byte, error) {
+	limit := c.MaxResponseBytes
+	if limit == 0 {
+		limit = DefaultMaxResponseBytes
+	}
+	if limit < 0 {
+		return ioutil.ReadAll(rc)
+	}
+	// Read one byte more than the limit so that we can
+	// detect bodies that exceed it.
+	blob, err := ioutil.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(blob)) > limit {
+		return nil, errResponseTooLarge
+	}
+	return blob, nil
+}
+
+// codedErrorFromResponse converts a failed response into a *CodedError,
+// preferring any error message in the body over the status text.
+func codedErrorFromResponse(res *http.Response, blob []byte) *CodedError {
+	msg := strings.TrimSpace(string(blob))
+
+	// Try to extract the message from common JSON error shapes.
+	var jsonErr struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if err := json.Unmarshal(blob, &jsonErr); err == nil {
+		var str string
+		switch {
+		case json.Unmarshal(jsonErr.Error, &str) == nil && str != "":
+			msg = str
+		case jsonErr.Message != "":
+			msg = jsonErr.Message
+		}
+	}
+	if msg == "" {
+		msg = res.Status
+	}
+	return MakeCodedError(msg, res.StatusCode)
+}
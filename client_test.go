@@ -0,0 +1,130 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientGetJSON(t *testing.T) {
+	type query struct {
+		Page int64 `json:"page"`
+	}
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		client   *Client
+		query    interface{}
+		want     []item
+		wantCode int
+	}{
+		{
+			name: "ok with query and headers",
+			client: &Client{
+				Header: http.Header{"X-Client": {"otils"}},
+				Auth: func(req *http.Request) error {
+					req.Header.Set("Authorization", "Bearer token")
+					return nil
+				},
+			},
+			query: &query{Page: 2},
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.URL.Query().Get("page"); got != "2" {
+					http.Error(rw, "bad page "+got, http.StatusBadRequest)
+					return
+				}
+				if req.Header.Get("X-Client") != "otils" || req.Header.Get("Authorization") != "Bearer token" {
+					http.Error(rw, "missing headers", http.StatusUnauthorized)
+					return
+				}
+				_ = json.NewEncoder(rw).Encode([]item{{ID: 1, Name: "a"}})
+			},
+			want: []item{{ID: 1, Name: "a"}},
+		},
+		{
+			name:   "json error body",
+			client: &Client{},
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusNotFound)
+				_, _ = rw.Write([]byte(`{"error":"no such item"}`))
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:   "body too large",
+			client: &Client{MaxResponseBytes: 4},
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte(`[{"id":1}]`))
+			},
+			wantCode: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tst := httptest.NewServer(tt.handler)
+			defer tst.Close()
+
+			tt.client.BaseURL = tst.URL + "/v1"
+			var got []item
+			err := tt.client.GetJSON(context.Background(), "/items", tt.query, &got)
+			switch {
+			case tt.wantCode == -1:
+				if !errors.Is(err, errResponseTooLarge) {
+					t.Fatalf("got err=%v want=%v", err, errResponseTooLarge)
+				}
+				return
+			case tt.wantCode != 0:
+				cerr := new(CodedError)
+				if !errors.As(err, &cerr) {
+					t.Fatalf("expected a *CodedError, got %T: %v", err, err)
+				}
+				if cerr.Code() != tt.wantCode {
+					t.Fatalf("gotCode=%d wantCode=%d", cerr.Code(), tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if string(asJSON(got)) != string(asJSON(tt.want)) {
+				t.Fatalf("got=%s want=%s", asJSON(got), asJSON(tt.want))
+			}
+		})
+	}
+}
+
+func TestClientPostJSON(t *testing.T) {
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+			http.Error(rw, "bad request", http.StatusBadRequest)
+			return
+		}
+		var in map[string]string
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in["echo"] = "true"
+		_ = json.NewEncoder(rw).Encode(in)
+	}))
+	defer tst.Close()
+
+	client := &Client{BaseURL: tst.URL}
+	var out map[string]string
+	if err := client.PostJSON(context.Background(), "echo", map[string]string{"name": "otils"}, &out); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if out["name"] != "otils" || out["echo"] != "true" {
+		t.Fatalf("unexpected response: %v", out)
+	}
+}
@@ -0,0 +1,213 @@
+package otils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses CIDRs such as "10.0.0.0/8", treating bare IP
+// addresses as networks containing only that address.
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("otils: invalid IP address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("otils: %w", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// defaultClientIPHeaders is only X-Forwarded-For since most proxies
+// append to it but pass on whatever Forwarded or X-Real-IP header the
+// client sent, which would let clients choose their own IP address.
+var defaultClientIPHeaders = []string{"X-Forwarded-For"}
+
+// ClientIPResolver determines the IP address of the client that sent a
+// request through proxies. The forwarding headers are only believed when
+// the request comes from one of the TrustedProxies, and are then walked
+// from right to left, each hop added by a proxy closer to the server,
+// until an address that is not a trusted proxy is found: that is the
+// client. Anything to its left could have been made up by the client.
+// Sample usage is:
+//
+//	trusted, err := otils.ParseCIDRs("10.0.0.0/8", "fd00::/8")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	resolver := &otils.ClientIPResolver{TrustedProxies: trusted}
+//	ip := resolver.ClientIP(req)
+type ClientIPResolver struct {
+	TrustedProxies []*net.IPNet
+
+	// Headers are the forwarding headers consulted, the first one present
+	// in a request being used. They default to X-Forwarded-For only; add
+	// Forwarded or X-Real-IP only if your proxies set or overwrite them,
+	// since otherwise clients can supply them.
+	Headers []string
+}
+
+// ClientIP returns the IP address of the client that sent req.
+func (r *ClientIPResolver) ClientIP(req *http.Request) string {
+	ip := remoteHost(req)
+	if r == nil || !ipInNets(ip, r.TrustedProxies) {
+		return ip
+	}
+
+	headers := r.Headers
+	if len(headers) == 0 {
+		headers = defaultClientIPHeaders
+	}
+	for _, header := range headers {
+		values := req.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+		var hops []string
+		switch http.CanonicalHeaderKey(header) {
+		case "Forwarded":
+			hops = forwardedFor(values)
+		case "X-Real-Ip":
+			// Only ever set by the proxy closest to the server.
+			hops = values[len(values)-1:]
+		default:
+			hops = strings.Split(strings.Join(values, ","), ",")
+		}
+		return walkHops(ip, hops, r.TrustedProxies)
+	}
+	return ip
+}
+
+// walkHops returns the rightmost hop that is not a trusted proxy, or the
+// leftmost valid one if they all are. An invalid hop stops the walk since
+// nothing to its left can be relied upon.
+func walkHops(ip string, hops []string, trusted []*net.IPNet) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := normalizeIP(hops[i])
+		if hop == "" {
+			break
+		}
+		ip = hop
+		if !ipInNets(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// normalizeIP returns the IP address in a hop such as "192.0.2.1",
+// "192.0.2.1:4711" or "[2001:db8::1]:4711", or "" if it has none.
+func normalizeIP(hop string) string {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	ip := net.ParseIP(strings.Trim(hop, "[]"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// forwardedFor extracts the for= parameters of Forwarded header
+// values, per RFC 7239, keeping "unknown" and obfuscated identifiers
+// so that they stop the walk.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+func ipInNets(ip string, nets []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPFilter is a middleware that allows or denies requests by the client's
+// IP address, responding to the denied ones with a 403 *CodedError.
+// Sample usage is:
+//
+//	allow, _ := otils.ParseCIDRs("192.0.2.0/24", "2001:db8::/32")
+//	handler := otils.IPFilterMiddleware(&otils.IPFilter{Allow: allow, Resolver: resolver}, adminMux)
+type IPFilter struct {
+	// Allow if set is the only networks that requests are allowed from.
+	Allow []*net.IPNet
+
+	// Deny is networks that requests are denied from, even if
+	// they are also in Allow.
+	Deny []*net.IPNet
+
+	// Resolver determines the client's IP address, if nil
+	// the address the request came from is used.
+	Resolver *ClientIPResolver
+
+	next http.Handler
+}
+
+// IPFilterMiddleware returns a handler that only passes the requests
+// allowed by f on to next. A nil IPFilter allows all requests.
+func IPFilterMiddleware(f *IPFilter, next http.Handler) http.Handler {
+	if f == nil {
+		return next
+	}
+	copy := new(IPFilter)
+	*copy = *f
+	copy.next = next
+	return copy
+}
+
+var _ http.Handler = (*IPFilter)(nil)
+
+func (f *IPFilter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !f.Allowed(f.Resolver.ClientIP(req)) {
+		WriteCodedError(rw, req, MakeCodedError("forbidden", http.StatusForbidden))
+		return
+	}
+	if f.next != nil {
+		f.next.ServeHTTP(rw, req)
+	}
+}
+
+// Allowed reports whether f allows requests from ip. Invalid
+// addresses are never allowed.
+func (f *IPFilter) Allowed(ip string) bool {
+	if net.ParseIP(ip) == nil || ipInNets(ip, f.Deny) {
+		return false
+	}
+	return len(f.Allow) == 0 || ipInNets(ip, f.Allow)
+}
@@ -0,0 +1,168 @@
+package otils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs("10.0.0.0/8", "192.0.2.7", "2001:db8::1", " fd00::/8 ")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::1/128", "fd00::/8"}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("#%d got=%q want=%q", i, n, want[i])
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "localhost", ""} {
+		if _, err := ParseCIDRs(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestClientIPResolver(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8", "fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		resolver   *ClientIPResolver
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{
+			name:       "nil resolver",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "untrusted peer cannot spoof",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted},
+			remoteAddr: "203.0.113.9:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.1"}},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "spoofed hops left of the client are ignored",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1", "10.0.0.2"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "all hops trusted",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "forwarded takes precedence when listed first",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted, Headers: []string{"Forwarded", "X-Forwarded-For"}},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {`for=1.2.3.4, for="[2001:db8::7]:4711";proto=https, for=10.0.0.2`},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: "2001:db8::7",
+		},
+		{
+			name:       "forwarded obfuscated hop stops the walk",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted, Headers: []string{"Forwarded"}},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {"for=198.51.100.1, for=_hidden, for=10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "x-real-ip",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted, Headers: []string{"X-Real-IP"}},
+			remoteAddr: "[fd00::1]:1234",
+			header:     http.Header{"X-Real-Ip": {"198.51.100.1"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "client injected forwarded is ignored by default",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {"for=192.0.2.99"},
+				"X-Real-Ip":       {"192.0.2.98"},
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name:       "only configured headers",
+			resolver:   &ClientIPResolver{TrustedProxies: trusted, Headers: []string{"X-Real-IP"}},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4"}, "X-Real-Ip": {"198.51.100.1"}},
+			want:       "198.51.100.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			if got := tt.resolver.ClientIP(req); got != tt.want {
+				t.Fatalf("got=%q want=%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	allow, _ := ParseCIDRs("192.0.2.0/24", "2001:db8::/32")
+	deny, _ := ParseCIDRs("192.0.2.66")
+	trusted, _ := ParseCIDRs("10.0.0.0/8")
+	filter := &IPFilter{Allow: allow, Deny: deny, Resolver: &ClientIPResolver{TrustedProxies: trusted}}
+	handler := IPFilterMiddleware(filter, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+
+	tests := []struct {
+		remoteAddr string
+		xff        string
+		wantCode   int
+	}{
+		0: {remoteAddr: "192.0.2.1:1234", wantCode: http.StatusOK},
+		1: {remoteAddr: "[2001:db8::1]:1234", wantCode: http.StatusOK},
+		2: {remoteAddr: "192.0.2.66:1234", wantCode: http.StatusForbidden},
+		3: {remoteAddr: "203.0.113.9:1234", wantCode: http.StatusForbidden},
+		4: {remoteAddr: "203.0.113.9:1234", xff: "192.0.2.1", wantCode: http.StatusForbidden},
+		5: {remoteAddr: "10.0.0.1:1234", xff: "192.0.2.1", wantCode: http.StatusOK},
+		6: {remoteAddr: "10.0.0.1:1234", xff: "192.0.2.66", wantCode: http.StatusForbidden},
+		7: {remoteAddr: "not-an-ip", wantCode: http.StatusForbidden},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d gotCode=%d wantCode=%d", i, rec.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantCode == http.StatusForbidden {
+			var body codedErrorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != http.StatusForbidden {
+				t.Errorf("#%d unexpected body %q", i, rec.Body)
+			}
+		}
+	}
+}
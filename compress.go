@@ -0,0 +1,260 @@
+package otils
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Compressor is a middleware that compresses responses with gzip or
+// deflate as negotiated by the request's Accept-Encoding header. Small
+// responses, responses that already have a Content-Encoding and already
+// compressed content types such as images, videos and archives are left
+// alone. Responses always carry "Vary: Accept-Encoding", added to rather
+// than replacing any Vary header set by CORSMiddleware or the handler.
+//
+// Flushing a response, as streaming handlers do, sends what was compressed
+// so far to the client.
+type Compressor struct {
+	// Level is the compression level, it defaults to gzip.DefaultCompression.
+	Level int
+
+	// MinSize is the minimum body size in bytes worth compressing, it
+	// defaults to 1024. Flushed responses are compressed regardless.
+	MinSize int
+
+	// SkipContentTypes if set replaces the default list of content types
+	// that are not compressed. Entries match as prefixes, so "image/"
+	// matches all images.
+	SkipContentTypes []string
+
+	next     http.Handler
+	gzipPool *sync.Pool
+	zlibPool *sync.Pool
+}
+
+var defaultSkipContentTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-bzip2", "application/x-7z-compressed", "application/x-rar-compressed",
+}
+
+// CompressMiddleware returns a handler that compresses the responses of
+// next. A nil Compressor uses the default settings.
+func CompressMiddleware(c *Compressor, next http.Handler) http.Handler {
+	copy := new(Compressor)
+	if c != nil {
+		*copy = *c
+	}
+	copy.next = next
+	if copy.Level == 0 {
+		copy.Level = gzip.DefaultCompression
+	}
+	if copy.MinSize <= 0 {
+		copy.MinSize = 1024
+	}
+	if copy.SkipContentTypes == nil {
+		copy.SkipContentTypes = defaultSkipContentTypes
+	}
+	level := copy.Level
+	copy.gzipPool = &sync.Pool{New: func() interface{} {
+		w, err := gzip.NewWriterLevel(io.Discard, level)
+		if err != nil {
+			w = gzip.NewWriter(io.Discard)
+		}
+		return w
+	}}
+	copy.zlibPool = &sync.Pool{New: func() interface{} {
+		w, err := zlib.NewWriterLevel(io.Discard, level)
+		if err != nil {
+			w = zlib.NewWriter(io.Discard)
+		}
+		return w
+	}}
+	return copy
+}
+
+var _ http.Handler = (*Compressor)(nil)
+
+func (c *Compressor) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if c.next == nil {
+		return
+	}
+	rw.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := NegotiateEncoding(req, "gzip", "deflate")
+	// Upgraded connections are hijacked and must not be wrapped.
+	if encoding == "" || req.Header.Get("Upgrade") != "" {
+		c.next.ServeHTTP(rw, req)
+		return
+	}
+
+	cw := &compressWriter{
+		rw:       rw,
+		c:        c,
+		encoding: encoding,
+		status:   http.StatusOK,
+		skip:     req.Method == http.MethodHead,
+	}
+	completed := false
+	// When next panics the buffered response must not be sent, so that
+	// RecoverMiddleware can still respond with its error.
+	defer func() { cw.close(completed) }()
+	c.next.ServeHTTP(cw, req)
+	completed = true
+}
+
+// compressor is implemented by *gzip.Writer and *zlib.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter buffers the start of a response until it can decide
+// whether compressing it is worthwhile, then streams it through.
+type compressWriter struct {
+	rw       http.ResponseWriter
+	c        *Compressor
+	encoding string
+	status   int
+	skip     bool
+
+	decided bool
+	buf     []byte
+	cw      compressor
+}
+
+func (w *compressWriter) Header() http.Header { return w.rw.Header() }
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.rw.WriteHeader(code)
+		return
+	}
+	if code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols {
+		w.rw.WriteHeader(code)
+		return
+	}
+	w.status = code
+	switch code {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent, http.StatusSwitchingProtocols:
+		w.skip = true
+	}
+	if w.skip {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.c.MinSize {
+			return len(b), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+	return w.rw.Write(b)
+}
+
+// Flush sends everything written so far to the client.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		_ = w.decide(true)
+	}
+	if w.cw != nil {
+		_ = w.cw.Flush()
+	}
+	if flusher, ok := w.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter { return w.rw }
+
+// decide writes the header, compressing the response if it is eligible,
+// and then writes out the buffered body.
+func (w *compressWriter) decide(flushing bool) error {
+	w.decided = true
+	header := w.rw.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	compress := !w.skip &&
+		(flushing || len(w.buf) >= w.c.MinSize) &&
+		header.Get("Content-Encoding") == "" &&
+		!w.skipContentType(header.Get("Content-Type"))
+	if compress {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		// The compressed representation is no longer byte for byte
+		// identical to the one a strong validator was computed for.
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.cw = w.pool().Get().(compressor)
+		w.cw.Reset(w.rw)
+	}
+	w.rw.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.cw != nil {
+		_, err = w.cw.Write(buf)
+	} else {
+		_, err = w.rw.Write(buf)
+	}
+	return err
+}
+
+func (w *compressWriter) skipContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+	for _, skip := range w.c.SkipContentTypes {
+		if strings.HasPrefix(mediaType, skip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *compressWriter) pool() *sync.Pool {
+	if w.encoding == "gzip" {
+		return w.c.gzipPool
+	}
+	return w.c.zlibPool
+}
+
+// close finishes the response if completed, otherwise it only
+// releases the compressor.
+func (w *compressWriter) close(completed bool) {
+	if completed && !w.decided {
+		_ = w.decide(false)
+	}
+	if w.cw != nil {
+		if completed {
+			_ = w.cw.Close()
+		}
+		w.cw.Reset(io.Discard)
+		w.pool().Put(w.cw)
+		w.cw = nil
+	}
+}
@@ -0,0 +1,133 @@
+package otils
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	large := strings.Repeat("otils compresses repetitive text well. ", 100)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantEncoding   string
+	}{
+		{"gzip", "gzip, deflate", "text/plain", large, "gzip"},
+		{"deflate preferred by q", "gzip;q=0.5, deflate", "text/plain", large, "deflate"},
+		{"wildcard", "*", "application/json", large, "gzip"},
+		{"gzip refused", "gzip;q=0, deflate;q=0", "text/plain", large, ""},
+		{"no Accept-Encoding", "", "text/plain", large, ""},
+		{"small body", "gzip", "text/plain", "tiny", ""},
+		{"already compressed type", "gzip", "image/png", large, ""},
+		{"svg is compressed", "gzip", "image/svg+xml", large, "gzip"},
+		{"sniffed content type", "gzip", "", large, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CORSMiddleware(&CORS{Origins: []string{"*"}}, CompressMiddleware(nil, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tt.contentType != "" {
+					rw.Header().Set("Content-Type", tt.contentType)
+				}
+				rw.Header().Add("Vary", "Origin")
+				rw.Header().Set("Content-Length", "12345")
+				_, _ = io.WriteString(rw, tt.body[:len(tt.body)/2])
+				_, _ = io.WriteString(rw, tt.body[len(tt.body)/2:])
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			res := rec.Result()
+			if got := res.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("gotEncoding=%q wantEncoding=%q", got, tt.wantEncoding)
+			}
+			if got, want := res.Header.Values("Vary"), []string{"Accept-Encoding", "Origin"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("gotVary=%q wantVary=%q", got, want)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+				t.Errorf("lost the CORS headers, got origin=%q", got)
+			}
+
+			var body io.Reader = res.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				body, _ = gzip.NewReader(res.Body)
+			case "deflate":
+				body, _ = zlib.NewReader(res.Body)
+			}
+			blob, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if string(blob) != tt.body {
+				t.Fatalf("body mismatch, got %d bytes want %d", len(blob), len(tt.body))
+			}
+			if tt.wantEncoding != "" && res.Header.Get("Content-Length") != "" {
+				t.Errorf("Content-Length was not removed from the compressed response")
+			}
+		})
+	}
+}
+
+func TestCompressMiddlewareFlush(t *testing.T) {
+	handler := CompressMiddleware(nil, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(rw, "data: first\n\n")
+		rw.(http.Flusher).Flush()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if !rec.Flushed {
+		t.Fatal("expected the response to be flushed")
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected flushed streams to be compressed, got %q", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	blob, _ := io.ReadAll(zr)
+	if string(blob) != "data: first\n\n" {
+		t.Fatalf("got body=%q", blob)
+	}
+}
+
+func TestCompressMiddlewarePanic(t *testing.T) {
+	handler := RecoverMiddleware(&Recoverer{
+		OnPanic: func(req *http.Request, recovered interface{}, stack []byte) {},
+	}, CompressMiddleware(nil, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "xxxxxxxxxx")
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("gotCode=%d wantCode=%d body=%q", rec.Code, http.StatusInternalServerError, rec.Body)
+	}
+	if got := rec.Body.String(); strings.Contains(got, "xxxxxxxxxx") {
+		t.Fatalf("the buffered partial body leaked: %q", got)
+	}
+}
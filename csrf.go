@@ -0,0 +1,269 @@
+package otils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSRFMode is how CSRF tokens are issued and checked.
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit issues a random token in a cookie which requests
+	// must echo in a header or form field. With a Secret the cookie is
+	// signed so that it cannot be planted by a sibling subdomain.
+	CSRFDoubleSubmit CSRFMode = iota
+
+	// CSRFSynchronizer derives the token from the session the request
+	// belongs to and the Secret, so nothing is stored. Requests without
+	// a session, such as logins, fall back to CSRFDoubleSubmit.
+	CSRFSynchronizer
+)
+
+// CSRF is a middleware that protects cookie authenticated endpoints from
+// cross-site request forgery. Safe methods (GET, HEAD, OPTIONS and TRACE)
+// always pass and get a token that templates embed with CSRFToken or
+// CSRFTemplateField. Other requests are rejected with a 403 *CodedError
+// if the browser reports them as cross-site through Sec-Fetch-Site, if
+// their Origin, or failing that Referer, is neither the requested host
+// nor one of TrustedOrigins, or if they lack a valid token.
+// Sample usage is:
+//
+//	handler := otils.CSRFMiddleware(&otils.CSRF{
+//		Mode:      otils.CSRFSynchronizer,
+//		Secret:    csrfSecret,
+//		SessionID: func(req *http.Request) string { return sessions.ID(req) },
+//	}, mux)
+//
+// with forms rendering {{ .CSRFField }} set from otils.CSRFTemplateField(req),
+// and JavaScript sending the token in the X-CSRF-Token header.
+type CSRF struct {
+	Mode CSRFMode
+
+	// Secret signs tokens, without it CSRFSynchronizer
+	// falls back to CSRFDoubleSubmit.
+	Secret []byte
+
+	// SessionID returns the ID of the session that req belongs
+	// to, or "" if none, for CSRFSynchronizer.
+	SessionID func(req *http.Request) string
+
+	// CookieName is the cookie holding the double submit
+	// token, it defaults to "csrf_token".
+	CookieName string
+
+	// InsecureCookie drops the Secure attribute of the cookie,
+	// for local development over plain HTTP.
+	InsecureCookie bool
+
+	// HeaderName is the header carrying the token,
+	// it defaults to "X-CSRF-Token".
+	HeaderName string
+
+	// FormField is the form field carrying the token,
+	// it defaults to "csrf_token".
+	FormField string
+
+	// TrustedOrigins are other origins allowed to make requests,
+	// e.g. "https://app.orijtech.com".
+	TrustedOrigins []string
+
+	// Exempt matches requests that are not checked, e.g. webhooks
+	// authenticated with signatures.
+	Exempt RequestMatcher
+
+	next http.Handler
+}
+
+// CSRFMiddleware returns a handler that only passes the requests that are
+// not forgeries on to next. A nil CSRF uses the default settings.
+func CSRFMiddleware(c *CSRF, next http.Handler) http.Handler {
+	copy := new(CSRF)
+	if c != nil {
+		*copy = *c
+	}
+	copy.next = next
+	if copy.CookieName == "" {
+		copy.CookieName = "csrf_token"
+	}
+	if copy.HeaderName == "" {
+		copy.HeaderName = "X-CSRF-Token"
+	}
+	if copy.FormField == "" {
+		copy.FormField = "csrf_token"
+	}
+	return copy
+}
+
+var _ http.Handler = (*CSRF)(nil)
+
+type csrfTokenKey struct{}
+
+type csrfToken struct {
+	token string
+	field string
+}
+
+func (c *CSRF) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if c.Exempt != nil && c.Exempt(req) {
+		c.serveNext(rw, req)
+		return
+	}
+
+	token := c.expectedToken(req)
+	if token == "" {
+		token = c.issueCookie(rw)
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+	default:
+		if err := c.check(req, token); err != nil {
+			WriteCodedError(rw, req, err)
+			return
+		}
+	}
+
+	ctx := context.WithValue(req.Context(), csrfTokenKey{}, &csrfToken{token: token, field: c.FormField})
+	c.serveNext(rw, req.WithContext(ctx))
+}
+
+func (c *CSRF) serveNext(rw http.ResponseWriter, req *http.Request) {
+	if c.next != nil {
+		c.next.ServeHTTP(rw, req)
+	}
+}
+
+// check rejects requests that come from another site or lack the token.
+func (c *CSRF) check(req *http.Request, token string) error {
+	forbidden := func(reason string) error {
+		return MakeCodedError("CSRF check failed: "+reason, http.StatusForbidden)
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		if referer, err := url.Parse(req.Referer()); err == nil && referer.Host != "" {
+			origin = referer.Scheme + "://" + referer.Host
+		}
+	}
+	site := req.Header.Get("Sec-Fetch-Site")
+	switch {
+	case origin == "null":
+		return forbidden("opaque origin")
+	case c.trustedOrigin(origin):
+		// Explicitly allowed, whatever Sec-Fetch-Site says.
+	case site == "cross-site":
+		return forbidden("cross-site request")
+	case origin != "" && !c.sameHost(req, origin):
+		return forbidden("origin " + origin + " is not allowed")
+	}
+
+	submitted := req.Header.Get(c.HeaderName)
+	if submitted == "" {
+		submitted = req.PostFormValue(c.FormField)
+	}
+	if submitted == "" {
+		return forbidden("missing token")
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		return forbidden("invalid token")
+	}
+	return nil
+}
+
+func (c *CSRF) trustedOrigin(origin string) bool {
+	for _, trusted := range c.TrustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(trusted, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameHost reports whether origin is for the host that req was sent to.
+// Schemes are not compared since TLS is often terminated by a proxy.
+func (c *CSRF) sameHost(req *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, req.Host)
+}
+
+// expectedToken returns the token that req must carry, or "" if the
+// client has yet to be issued one.
+func (c *CSRF) expectedToken(req *http.Request) string {
+	if c.Mode == CSRFSynchronizer && c.SessionID != nil && len(c.Secret) > 0 {
+		if sid := c.SessionID(req); sid != "" {
+			return c.sign("session:" + sid)
+		}
+	}
+
+	cookie, err := req.Cookie(c.CookieName)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	if len(c.Secret) == 0 {
+		return cookie.Value
+	}
+	raw, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(c.sign("cookie:"+raw))) {
+		// Possibly planted, replace it with one of ours.
+		return ""
+	}
+	return cookie.Value
+}
+
+// issueCookie sets a new double submit token cookie and returns the token.
+func (c *CSRF) issueCookie(rw http.ResponseWriter) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("otils: reading random bytes: %v", err))
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if len(c.Secret) > 0 {
+		token += "." + c.sign("cookie:"+token)
+	}
+	// The cookie is readable by scripts so that they can echo it in
+	// the header, which is what the other site's scripts cannot do.
+	http.SetCookie(rw, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   !c.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+func (c *CSRF) sign(msg string) string {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(msg))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFToken returns the token that requests following req, such as
+// form submissions, must carry, as set by CSRFMiddleware.
+func CSRFToken(req *http.Request) string {
+	if t, ok := req.Context().Value(csrfTokenKey{}).(*csrfToken); ok {
+		return t.token
+	}
+	return ""
+}
+
+// CSRFTemplateField returns a hidden form input carrying the CSRF token,
+// for html/template.
+func CSRFTemplateField(req *http.Request) template.HTML {
+	t, ok := req.Context().Value(csrfTokenKey{}).(*csrfToken)
+	if !ok {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(t.field) +
+		`" value="` + template.HTMLEscapeString(t.token) + `">`)
+}
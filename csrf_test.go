@@ -0,0 +1,205 @@
+package otils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	var seenToken string
+	handler := CSRFMiddleware(&CSRF{
+		Secret:         []byte("orijtech-csrf-secret"),
+		TrustedOrigins: []string{"https://app.orijtech.com"},
+		Exempt:         MatchPathPrefix("/webhooks"),
+	}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		seenToken = CSRFToken(req)
+	}))
+
+	// A safe request is issued a token in a cookie and the context.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://orijtech.com/form", nil))
+	res := rec.Result()
+	if len(res.Cookies()) != 1 || rec.Code != http.StatusOK {
+		t.Fatalf("expected a token cookie, got %v", res.Cookies())
+	}
+	cookie := res.Cookies()[0]
+	if cookie.Value != seenToken || !cookie.Secure || cookie.Name != "csrf_token" {
+		t.Fatalf("cookie %+v does not carry the context's token %q", cookie, seenToken)
+	}
+	token := cookie.Value
+	forged := strings.SplitN(token, ".", 2)[0] + ".forged"
+
+	tests := []struct {
+		name     string
+		url      string
+		header   http.Header
+		form     url.Values
+		cookie   string
+		wantCode int
+		wantMsg  string
+	}{
+		{
+			name:     "header token",
+			header:   http.Header{"X-Csrf-Token": {token}, "Origin": {"https://orijtech.com"}},
+			cookie:   token,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "form token",
+			form:     url.Values{"csrf_token": {token}},
+			cookie:   token,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "trusted origin",
+			header:   http.Header{"X-Csrf-Token": {token}, "Origin": {"https://app.orijtech.com"}, "Sec-Fetch-Site": {"same-site"}},
+			cookie:   token,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "missing token",
+			cookie:   token,
+			wantCode: http.StatusForbidden,
+			wantMsg:  "CSRF check failed: missing token",
+		},
+		{
+			name:     "mismatched token",
+			header:   http.Header{"X-Csrf-Token": {"guess"}},
+			cookie:   token,
+			wantCode: http.StatusForbidden,
+			wantMsg:  "CSRF check failed: invalid token",
+		},
+		{
+			name:     "planted cookie",
+			header:   http.Header{"X-Csrf-Token": {forged}},
+			cookie:   forged,
+			wantCode: http.StatusForbidden,
+			wantMsg:  "CSRF check failed: invalid token",
+		},
+		{
+			name:     "cross-site fetch",
+			header:   http.Header{"X-Csrf-Token": {token}, "Sec-Fetch-Site": {"cross-site"}},
+			cookie:   token,
+			wantCode: http.StatusForbidden,
+			wantMsg:  "CSRF check failed: cross-site request",
+		},
+		{
+			name:     "foreign origin",
+			header:   http.Header{"X-Csrf-Token": {token}, "Origin": {"https://evil.example"}},
+			cookie:   token,
+			wantCode: http.StatusForbidden,
+			wantMsg:  "CSRF check failed: origin https://evil.example is not allowed",
+		},
+		{
+			name:     "foreign referer",
+			header:   http.Header{"X-Csrf-Token": {token}, "Referer": {"https://evil.example/page"}},
+			cookie:   token,
+			wantCode: http.StatusForbidden,
+			wantMsg:  "CSRF check failed: origin https://evil.example is not allowed",
+		},
+		{
+			name:     "null origin",
+			header:   http.Header{"X-Csrf-Token": {token}, "Origin": {"null"}},
+			cookie:   token,
+			wantCode: http.StatusForbidden,
+			wantMsg:  "CSRF check failed: opaque origin",
+		},
+		{
+			name:     "exempt",
+			url:      "https://orijtech.com/webhooks/stripe",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.url
+			if target == "" {
+				target = "https://orijtech.com/form"
+			}
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "csrf_token", Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d body=%s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantMsg != "" {
+				var body codedErrorBody
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != tt.wantMsg {
+					t.Fatalf("got=%q want=%q", rec.Body, tt.wantMsg)
+				}
+			}
+		})
+	}
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	sessionOf := func(req *http.Request) string { return req.Header.Get("X-Session") }
+	newHandler := func(secret string) http.Handler {
+		return CSRFMiddleware(&CSRF{
+			Mode:      CSRFSynchronizer,
+			Secret:    []byte(secret),
+			SessionID: sessionOf,
+		}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte(CSRFTemplateField(req)))
+		}))
+	}
+	handler := newHandler("orijtech-csrf-secret")
+
+	tokenFor := func(session string) string {
+		req := httptest.NewRequest(http.MethodGet, "/form", nil)
+		req.Header.Set("X-Session", session)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if len(rec.Result().Cookies()) != 0 {
+			t.Fatal("no cookie should be set for requests with a session")
+		}
+		field := rec.Body.String()
+		if !strings.HasPrefix(field, `<input type="hidden" name="csrf_token" value="`) {
+			t.Fatalf("unexpected field %q", field)
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(field, `<input type="hidden" name="csrf_token" value="`), `">`)
+	}
+	alice, bob := tokenFor("alice"), tokenFor("bob")
+	if alice == bob || alice != tokenFor("alice") {
+		t.Fatalf("tokens must be stable per session and differ across sessions: %q %q", alice, bob)
+	}
+
+	tests := []struct {
+		session  string
+		token    string
+		handler  http.Handler
+		wantCode int
+	}{
+		0: {session: "alice", token: alice, wantCode: http.StatusOK},
+		1: {session: "alice", token: bob, wantCode: http.StatusForbidden},
+		2: {session: "bob", token: bob, wantCode: http.StatusOK},
+		3: {session: "alice", token: alice, handler: newHandler("rotated"), wantCode: http.StatusForbidden},
+	}
+	for i, tt := range tests {
+		h := tt.handler
+		if h == nil {
+			h = handler
+		}
+		req := httptest.NewRequest(http.MethodDelete, "/items/1", nil)
+		req.Header.Set("X-Session", tt.session)
+		req.Header.Set("X-CSRF-Token", tt.token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d gotCode=%d wantCode=%d", i, rec.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package otils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is the maximum request body size that
+// DecodeJSONBody accepts if DecodeOptions.MaxBytes is unset.
+const DefaultMaxBodyBytes = 1 << 20
+
+// DecodeOptions configures DecodeJSONBody.
+type DecodeOptions struct {
+	// MaxBytes bounds the size of the body,
+	// it defaults to DefaultMaxBodyBytes.
+	MaxBytes int64
+
+	// AllowUnknownFields accepts objects with fields
+	// that do not exist in the destination.
+	AllowUnknownFields bool
+
+	// SkipContentTypeCheck accepts bodies whatever their Content-Type,
+	// otherwise it must be application/json or a +json media type.
+	SkipContentTypeCheck bool
+}
+
+// DecodeJSONBody decodes the JSON body of req into dst. It enforces the
+// Content-Type, a maximum body size, rejects unknown fields and trailing
+// data after the JSON value, and returns *CodedErrors with messages fit
+// for clients: 415 for a wrong Content-Type, 413 for a body that is too
+// large and 400 otherwise, naming the path of the offending field, e.g.
+//
+//	invalid value for "customer.zip": expected number, got string
+//
+// Sample usage is:
+//
+//	var order Order
+//	if err := otils.DecodeJSONBody(rw, req, &order, nil); err != nil {
+//		otils.WriteCodedError(rw, req, err)
+//		return
+//	}
+func DecodeJSONBody(rw http.ResponseWriter, req *http.Request, dst interface{}, opts *DecodeOptions) error {
+	if opts == nil {
+		opts = new(DecodeOptions)
+	}
+	if !opts.SkipContentTypeCheck {
+		if err := checkJSONContentType(req.Header.Get("Content-Type")); err != nil {
+			return err
+		}
+	}
+
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+	if req.Body == nil {
+		return MakeCodedError("request body must not be empty", http.StatusBadRequest)
+	}
+	body := http.MaxBytesReader(rw, req.Body, maxBytes)
+
+	dec := json.NewDecoder(body)
+	if !opts.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(new(json.RawMessage)); err != io.EOF {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return decodeError(err)
+		}
+		return MakeCodedError("request body must only contain a single JSON value", http.StatusBadRequest)
+	}
+	return nil
+}
+
+func checkJSONContentType(contentType string) error {
+	if contentType == "" {
+		return MakeCodedError("Content-Type must be application/json", http.StatusUnsupportedMediaType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return MakeCodedError(fmt.Sprintf("Content-Type %q is not supported, use application/json", contentType), http.StatusUnsupportedMediaType)
+	}
+	return nil
+}
+
+// decodeError converts an error from encoding/json into a *CodedError.
+func decodeError(err error) *CodedError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxErr):
+		return MakeCodedError(fmt.Sprintf("request body must not be larger than %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, io.EOF):
+		return MakeCodedError("request body must not be empty", http.StatusBadRequest)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return MakeCodedError("request body contains malformed JSON", http.StatusBadRequest)
+	case errors.As(err, &syntaxErr):
+		return MakeCodedError(fmt.Sprintf("request body contains malformed JSON at offset %d", syntaxErr.Offset), http.StatusBadRequest)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return MakeCodedError(fmt.Sprintf("request body must be %s, got %s", jsonKind(typeErr.Type), typeErr.Value), http.StatusBadRequest)
+		}
+		return MakeCodedError(fmt.Sprintf("invalid value for %q: expected %s, got %s", typeErr.Field, jsonKind(typeErr.Type), typeErr.Value), http.StatusBadRequest)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json does not export a type for this error.
+		return MakeCodedError("request body contains unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field "), http.StatusBadRequest)
+	default:
+		return MakeCodedError("request body is invalid: "+strings.TrimPrefix(err.Error(), "json: "), http.StatusBadRequest)
+	}
+}
+
+// jsonKind describes the kind of a Go type as a JSON kind, so that named
+// types like time.Duration are described by their underlying kind.
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
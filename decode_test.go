@@ -0,0 +1,168 @@
+package otils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeJSONBody(t *testing.T) {
+	type item struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+	}
+	type customer struct {
+		Name string `json:"name"`
+		Zip  int    `json:"zip"`
+	}
+	type status int
+	type order struct {
+		ID       int           `json:"id"`
+		Items    []item        `json:"items"`
+		Customer *customer     `json:"customer"`
+		Status   status        `json:"status"`
+		Timeout  time.Duration `json:"timeout"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		opts        *DecodeOptions
+		wantCode    int
+		wantMsg     string
+	}{
+		{
+			name:        "ok",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"id": 1, "items": [{"name": "pen", "price": 1.5}]}`,
+		},
+		{
+			name:        "vendor media type",
+			contentType: "application/vnd.orijtech+json",
+			body:        `{"id": 1}`,
+		},
+		{
+			name:        "wrong content type",
+			contentType: "text/plain",
+			body:        `{"id": 1}`,
+			wantCode:    http.StatusUnsupportedMediaType,
+			wantMsg:     `Content-Type "text/plain" is not supported, use application/json`,
+		},
+		{
+			name:     "missing content type",
+			body:     `{"id": 1}`,
+			wantCode: http.StatusUnsupportedMediaType,
+			wantMsg:  "Content-Type must be application/json",
+		},
+		{
+			name:     "content type check skipped",
+			body:     `{"id": 1}`,
+			opts:     &DecodeOptions{SkipContentTypeCheck: true},
+			wantCode: 0,
+		},
+		{
+			name:        "too large",
+			contentType: "application/json",
+			body:        `{"id": 1, "items": []}`,
+			opts:        &DecodeOptions{MaxBytes: 8},
+			wantCode:    http.StatusRequestEntityTooLarge,
+			wantMsg:     "request body must not be larger than 8 bytes",
+		},
+		{
+			name:        "empty",
+			contentType: "application/json",
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     "request body must not be empty",
+		},
+		{
+			name:        "syntax error",
+			contentType: "application/json",
+			body:        `{"id": 1,}`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     "request body contains malformed JSON at offset 10",
+		},
+		{
+			name:        "truncated",
+			contentType: "application/json",
+			body:        `{"id": 1`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     "request body contains malformed JSON",
+		},
+		{
+			name:        "nested type error",
+			contentType: "application/json",
+			body:        `{"id": 1, "customer": {"name": "otils", "zip": "94107"}}`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     `invalid value for "customer.zip": expected number, got string`,
+		},
+		{
+			name:        "named number type",
+			contentType: "application/json",
+			body:        `{"id": 1, "status": "shipped"}`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     `invalid value for "status": expected number, got string`,
+		},
+		{
+			name:        "named standard library type",
+			contentType: "application/json",
+			body:        `{"id": 1, "timeout": "5s"}`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     `invalid value for "timeout": expected number, got string`,
+		},
+		{
+			name:        "top level type error",
+			contentType: "application/json",
+			body:        `[1, 2]`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     "request body must be object, got array",
+		},
+		{
+			name:        "unknown field",
+			contentType: "application/json",
+			body:        `{"id": 1, "coupon": "FREE"}`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     `request body contains unknown field "coupon"`,
+		},
+		{
+			name:        "unknown field allowed",
+			contentType: "application/json",
+			body:        `{"id": 1, "coupon": "FREE"}`,
+			opts:        &DecodeOptions{AllowUnknownFields: true},
+		},
+		{
+			name:        "multiple values",
+			contentType: "application/json",
+			body:        `{"id": 1}{"id": 2}`,
+			wantCode:    http.StatusBadRequest,
+			wantMsg:     "request body must only contain a single JSON value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			var dst order
+			err := DecodeJSONBody(httptest.NewRecorder(), req, &dst, tt.opts)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+				if dst.ID != 1 {
+					t.Fatalf("failed to decode, got %+v", dst)
+				}
+				return
+			}
+
+			cerr := AsCodedError(err)
+			if cerr.Code() != tt.wantCode || cerr.Error() != tt.wantMsg {
+				t.Fatalf("got=(%d, %q) want=(%d, %q)", cerr.Code(), cerr.Error(), tt.wantCode, tt.wantMsg)
+			}
+		})
+	}
+}
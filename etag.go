@@ -0,0 +1,279 @@
+package otils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagger is a middleware that adds ETags to responses and answers
+// conditional requests. Responses to GET and HEAD requests are buffered,
+// up to MaxBufferBytes, so that an ETag can be computed from their body
+// unless the handler set one. Requests whose If-None-Match, or otherwise
+// If-Modified-Since, shows that the client has the current representation
+// get a 304 Not Modified without a body.
+//
+// For other methods, If-Match and If-Unmodified-Since preconditions are
+// enforced against the resource's current representation, failing with a
+// 412 *CodedError. The current ETag is obtained from CurrentETag or, if it
+// is unset, by serving a GET request for the same URL to the next handler,
+// which must therefore be free of side effects.
+type ETagger struct {
+	// MaxBufferBytes is the largest body buffered to compute an ETag, it
+	// defaults to 1MB. Larger responses are streamed without an ETag.
+	MaxBufferBytes int
+
+	// Weak makes computed ETags weak validators, e.g. W/"xyz".
+	Weak bool
+
+	// CurrentETag if set returns the current ETag and last modification
+	// time of the resource targeted by a request, with an empty ETag
+	// signifying that the resource does not exist.
+	CurrentETag func(req *http.Request) (etag string, lastModified time.Time, err error)
+
+	next http.Handler
+}
+
+// ETagMiddleware returns a handler that adds ETags to the responses of next
+// and evaluates conditional requests. A nil ETagger uses the default settings.
+func ETagMiddleware(et *ETagger, next http.Handler) http.Handler {
+	copy := new(ETagger)
+	if et != nil {
+		*copy = *et
+	}
+	copy.next = next
+	if copy.MaxBufferBytes <= 0 {
+		copy.MaxBufferBytes = 1 << 20
+	}
+	return copy
+}
+
+var _ http.Handler = (*ETagger)(nil)
+
+func (et *ETagger) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if et.next == nil {
+		return
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		et.serveRead(rw, req)
+	default:
+		if err := et.checkPreconditions(req); err != nil {
+			WriteCodedError(rw, req, err)
+			return
+		}
+		et.next.ServeHTTP(rw, req)
+	}
+}
+
+func (et *ETagger) serveRead(rw http.ResponseWriter, req *http.Request) {
+	bw := &etagWriter{rw: rw, limit: et.MaxBufferBytes, status: http.StatusOK}
+	et.next.ServeHTTP(bw, req)
+	if bw.streaming {
+		return
+	}
+
+	header := rw.Header()
+	// Handlers may skip writing the body of HEAD responses, in
+	// which case there is nothing to compute an ETag from.
+	hasBody := req.Method == http.MethodGet || bw.body.Len() > 0
+	if bw.status == http.StatusOK && header.Get("ETag") == "" && hasBody {
+		header.Set("ETag", computeETag(bw.body.Bytes(), et.Weak))
+	}
+	if bw.status == http.StatusOK && notModified(req, header) {
+		// Mirror what net/http does for 304s.
+		delete(header, "Content-Type")
+		delete(header, "Content-Length")
+		delete(header, "Content-Encoding")
+		if header.Get("ETag") != "" {
+			delete(header, "Last-Modified")
+		}
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.WriteHeader(bw.status)
+	_, _ = rw.Write(bw.body.Bytes())
+}
+
+// notModified reports whether the client already has the
+// representation described by header, per RFC 7232 section 6.
+func notModified(req *http.Request, header http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, header.Get("ETag"), false)
+	}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// checkPreconditions evaluates the If-Match and If-Unmodified-Since
+// preconditions of a state changing request.
+func (et *ETagger) checkPreconditions(req *http.Request) error {
+	ifMatch := req.Header.Get("If-Match")
+	ifUnmodifiedSince := req.Header.Get("If-Unmodified-Since")
+	if ifMatch == "" && ifUnmodifiedSince == "" {
+		return nil
+	}
+
+	etag, lastModified, err := et.current(req)
+	if err != nil {
+		return err
+	}
+	failed := MakeCodedError("precondition failed", http.StatusPreconditionFailed)
+	if ifMatch != "" {
+		if etag == "" || (strings.TrimSpace(ifMatch) != "*" && !etagListMatches(ifMatch, etag, true)) {
+			return failed
+		}
+		return nil
+	}
+	if ius, err := http.ParseTime(ifUnmodifiedSince); err == nil && !lastModified.IsZero() && lastModified.After(ius) {
+		return failed
+	}
+	return nil
+}
+
+// current returns the current ETag and modification time of the
+// resource targeted by req.
+func (et *ETagger) current(req *http.Request) (string, time.Time, error) {
+	if et.CurrentETag != nil {
+		return et.CurrentETag(req)
+	}
+
+	get := req.Clone(req.Context())
+	get.Method = http.MethodGet
+	get.Body = http.NoBody
+	get.ContentLength = 0
+	for _, key := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range"} {
+		get.Header.Del(key)
+	}
+	rec := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	et.next.ServeHTTP(rec, get)
+	if rec.status != http.StatusOK {
+		return "", time.Time{}, nil
+	}
+	etag := rec.header.Get("ETag")
+	if etag == "" {
+		etag = computeETag(rec.body.Bytes(), et.Weak)
+	}
+	lastModified, _ := http.ParseTime(rec.header.Get("Last-Modified"))
+	return etag, lastModified, nil
+}
+
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// etagListMatches reports whether any ETag in the comma separated list
+// matches etag, using the strong or weak comparison of RFC 7232 2.3.2.
+func etagListMatches(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter buffers a response until it ends or outgrows the limit,
+// in which case it streams the rest of it.
+type etagWriter struct {
+	rw          http.ResponseWriter
+	limit       int
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	streaming   bool
+}
+
+func (w *etagWriter) Header() http.Header { return w.rw.Header() }
+
+func (w *etagWriter) WriteHeader(code int) {
+	if w.streaming || (code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols) {
+		w.rw.WriteHeader(code)
+		return
+	}
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.rw.Write(b)
+	}
+	if w.body.Len()+len(b) > w.limit {
+		if err := w.stream(); err != nil {
+			return 0, err
+		}
+		return w.rw.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+// Flush gives up on buffering and sends what was written so far.
+func (w *etagWriter) Flush() {
+	if !w.streaming {
+		_ = w.stream()
+	}
+	if flusher, ok := w.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *etagWriter) Unwrap() http.ResponseWriter { return w.rw }
+
+func (w *etagWriter) stream() error {
+	w.streaming = true
+	w.rw.WriteHeader(w.status)
+	_, err := w.rw.Write(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response in memory.
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (br *bufferedResponse) Header() http.Header { return br.header }
+
+func (br *bufferedResponse) WriteHeader(code int) {
+	if !br.wroteHeader {
+		br.status = code
+		br.wroteHeader = true
+	}
+}
+
+func (br *bufferedResponse) Write(b []byte) (int, error) {
+	br.wroteHeader = true
+	return br.body.Write(b)
+}
@@ -0,0 +1,87 @@
+package otils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETagMiddleware(t *testing.T) {
+	lastModified := time.Date(2021, time.January, 2, 15, 4, 5, 0, time.UTC)
+	body := `{"id":1,"name":"otils"}`
+	etag := computeETag([]byte(body), false)
+
+	handler := ETagMiddleware(nil, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+			_, _ = io.WriteString(rw, body)
+		case http.MethodPut:
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	tests := []struct {
+		name     string
+		method   string
+		header   http.Header
+		wantCode int
+		wantBody string
+	}{
+		{"plain GET", http.MethodGet, nil, http.StatusOK, body},
+		{"matching If-None-Match", http.MethodGet, http.Header{"If-None-Match": {`"other", ` + etag}}, http.StatusNotModified, ""},
+		{"weakly matching If-None-Match", http.MethodGet, http.Header{"If-None-Match": {"W/" + etag}}, http.StatusNotModified, ""},
+		{"stale If-None-Match", http.MethodGet, http.Header{"If-None-Match": {`"stale"`}}, http.StatusOK, body},
+		{"If-Modified-Since", http.MethodGet, http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}}, http.StatusNotModified, ""},
+		{"old If-Modified-Since", http.MethodGet, http.Header{"If-Modified-Since": {lastModified.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusOK, body},
+		{"PUT without preconditions", http.MethodPut, nil, http.StatusNoContent, ""},
+		{"PUT with matching If-Match", http.MethodPut, http.Header{"If-Match": {etag}}, http.StatusNoContent, ""},
+		{"PUT with stale If-Match", http.MethodPut, http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed, `{"code":412,"error":"precondition failed"}`},
+		{"PUT with weak If-Match", http.MethodPut, http.Header{"If-Match": {"W/" + etag}}, http.StatusPreconditionFailed, `{"code":412,"error":"precondition failed"}`},
+		{"PUT with If-Match *", http.MethodPut, http.Header{"If-Match": {"*"}}, http.StatusNoContent, ""},
+		{"PUT with stale If-Unmodified-Since", http.MethodPut, http.Header{"If-Unmodified-Since": {lastModified.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusPreconditionFailed, `{"code":412,"error":"precondition failed"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/items/1", nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d", rec.Code, tt.wantCode)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Fatalf("gotBody=%q wantBody=%q", got, tt.wantBody)
+			}
+			if tt.method == http.MethodGet && rec.Header().Get("ETag") != etag {
+				t.Fatalf("gotETag=%q wantETag=%q", rec.Header().Get("ETag"), etag)
+			}
+		})
+	}
+}
+
+func TestETagMiddlewareStreamsLargeBodies(t *testing.T) {
+	body := strings.Repeat("x", 64)
+	handler := ETagMiddleware(&ETagger{MaxBufferBytes: 16, Weak: true}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		for i := 0; i < 4; i++ {
+			_, _ = io.WriteString(rw, body[i*16:(i+1)*16])
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Body.String() != body {
+		t.Fatalf("got body=%q", rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got != "" {
+		t.Fatalf("expected no ETag on a streamed body, got %q", got)
+	}
+}
@@ -1,3 +1,7 @@
 module github.com/orijtech/otils
 
-go 1.16
+go 1.21
+
+require golang.org/x/crypto v0.31.0
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,237 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health statuses reported by Health.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthFailing  = "failing"
+)
+
+// HealthCheck is a named check of a component that a service depends on.
+type HealthCheck struct {
+	Name string
+
+	// Check returns a non-nil error if the component is unhealthy.
+	Check func(ctx context.Context) error
+
+	// Timeout bounds how long Check may run, it defaults to 5s.
+	Timeout time.Duration
+
+	// Critical checks make the service unhealthy when they fail,
+	// other checks only make it degraded.
+	Critical bool
+
+	// Liveness checks are also run by the liveness handler, they should
+	// only fail if the process needs restarting.
+	Liveness bool
+}
+
+// HealthReport is the JSON body served by Health's handlers.
+type HealthReport struct {
+	Status string                        `json:"status"`
+	Checks map[string]*HealthCheckResult `json:"checks,omitempty"`
+}
+
+// HealthCheckResult is the outcome of a HealthCheck.
+type HealthCheckResult struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Health runs registered health checks concurrently and serves their
+// results as a HealthReport, with a 200 status code when all critical
+// checks pass and a 503 otherwise. Results are cached for CacheTTL so that
+// frequent probes do not overload dependencies.
+// Sample usage is:
+//
+//	health := &otils.Health{CacheTTL: 2 * time.Second}
+//	health.Register(otils.HealthCheck{Name: "db", Check: db.PingContext, Critical: true})
+//	mux.Handle("/healthz", health.Liveness())
+//	mux.Handle("/readyz", health.Readiness())
+//
+// The zero value is ready to use.
+type Health struct {
+	// CacheTTL is how long results are reused, it defaults to 1s.
+	CacheTTL time.Duration
+
+	notReady atomic.Bool
+
+	mu     sync.Mutex
+	checks []HealthCheck
+	cache  map[bool]*cachedHealthReport
+}
+
+type cachedHealthReport struct {
+	report *HealthReport
+	at     time.Time
+}
+
+// Register adds check to the checks run by h.
+func (h *Health) Register(check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks = append(h.checks, check)
+	h.cache = nil
+}
+
+// SetReady marks the service as ready or not. A service that is not ready
+// fails its readiness checks without running them, which Serve uses to
+// take it out of load balancers before draining connections.
+func (h *Health) SetReady(ready bool) {
+	h.notReady.Store(!ready)
+}
+
+// Readiness returns a handler reporting whether the service is ready to
+// serve traffic, running all registered checks.
+func (h *Health) Readiness() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		h.serve(rw, req, false)
+	})
+}
+
+// Liveness returns a handler reporting whether the process is alive,
+// running only the checks marked as Liveness.
+func (h *Health) Liveness() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		h.serve(rw, req, true)
+	})
+}
+
+var _ http.Handler = (*Health)(nil)
+
+// ServeHTTP serves the readiness report.
+func (h *Health) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	h.serve(rw, req, false)
+}
+
+func (h *Health) serve(rw http.ResponseWriter, req *http.Request, liveness bool) {
+	report := h.Report(req.Context(), liveness)
+	code := http.StatusOK
+	if report.Status == HealthFailing {
+		code = http.StatusServiceUnavailable
+	}
+
+	blob, _ := json.Marshal(report)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(code)
+	_, _ = rw.Write(append(blob, '\n'))
+}
+
+// Report runs the checks, or only the liveness checks if liveness is set,
+// unless results younger than CacheTTL are available.
+func (h *Health) Report(ctx context.Context, liveness bool) *HealthReport {
+	if !liveness && h.notReady.Load() {
+		return &HealthReport{
+			Status: HealthFailing,
+			Checks: map[string]*HealthCheckResult{
+				"ready": {Status: HealthFailing, Critical: true, Duration: "0s", Error: "not ready"},
+			},
+		}
+	}
+
+	// Holding the lock while running the checks also makes
+	// concurrent probes share a single run.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ttl := h.CacheTTL
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	if cached := h.cache[liveness]; cached != nil && time.Since(cached.at) < ttl {
+		return cached.report
+	}
+
+	var checks []HealthCheck
+	for _, check := range h.checks {
+		if !liveness || check.Liveness {
+			checks = append(checks, check)
+		}
+	}
+	// The results are shared with other probes so they must not
+	// be cut short by the caller that happened to trigger the run.
+	report := runHealthChecks(context.WithoutCancel(ctx), checks)
+
+	if h.cache == nil {
+		h.cache = make(map[bool]*cachedHealthReport)
+	}
+	h.cache[liveness] = &cachedHealthReport{report: report, at: time.Now()}
+	return report
+}
+
+func runHealthChecks(ctx context.Context, checks []HealthCheck) *HealthReport {
+	report := &HealthReport{Status: HealthOK, Checks: make(map[string]*HealthCheckResult, len(checks))}
+	results := make([]*HealthCheckResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, check := range checks {
+		res := results[i]
+		report.Checks[check.Name] = res
+		if res.Status == HealthOK {
+			continue
+		}
+		if check.Critical {
+			report.Status = HealthFailing
+		} else if report.Status == HealthOK {
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+func runHealthCheck(ctx context.Context, check HealthCheck) *HealthCheckResult {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errc <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	res := &HealthCheckResult{Status: HealthOK, Critical: check.Critical, Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		res.Status = HealthFailing
+		res.Error = err.Error()
+	}
+	return res
+}
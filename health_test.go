@@ -0,0 +1,174 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("connection refused") }
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name       string
+		checks     []HealthCheck
+		liveness   bool
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "no checks",
+			wantCode:   http.StatusOK,
+			wantStatus: HealthOK,
+		},
+		{
+			name: "all passing",
+			checks: []HealthCheck{
+				{Name: "db", Check: ok, Critical: true},
+				{Name: "cache", Check: ok},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: HealthOK,
+			wantChecks: map[string]string{"db": HealthOK, "cache": HealthOK},
+		},
+		{
+			name: "non critical failing",
+			checks: []HealthCheck{
+				{Name: "db", Check: ok, Critical: true},
+				{Name: "cache", Check: fail},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: HealthDegraded,
+			wantChecks: map[string]string{"db": HealthOK, "cache": HealthFailing},
+		},
+		{
+			name: "critical failing",
+			checks: []HealthCheck{
+				{Name: "db", Check: fail, Critical: true},
+				{Name: "cache", Check: fail},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: HealthFailing,
+			wantChecks: map[string]string{"db": HealthFailing, "cache": HealthFailing},
+		},
+		{
+			name: "critical timing out",
+			checks: []HealthCheck{
+				{Name: "db", Check: hang, Critical: true, Timeout: 20 * time.Millisecond},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: HealthFailing,
+			wantChecks: map[string]string{"db": HealthFailing},
+		},
+		{
+			name: "critical panicking",
+			checks: []HealthCheck{
+				{Name: "db", Check: func(ctx context.Context) error { panic("nil conn") }, Critical: true},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: HealthFailing,
+			wantChecks: map[string]string{"db": HealthFailing},
+		},
+		{
+			name: "liveness skips readiness checks",
+			checks: []HealthCheck{
+				{Name: "db", Check: fail, Critical: true},
+				{Name: "deadlock", Check: ok, Critical: true, Liveness: true},
+			},
+			liveness:   true,
+			wantCode:   http.StatusOK,
+			wantStatus: HealthOK,
+			wantChecks: map[string]string{"deadlock": HealthOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := new(Health)
+			for _, check := range tt.checks {
+				health.Register(check)
+			}
+			handler := health.Readiness()
+			if tt.liveness {
+				handler = health.Liveness()
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d", rec.Code, tt.wantCode)
+			}
+			var report HealthReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("failed to parse report: %v", err)
+			}
+			if report.Status != tt.wantStatus {
+				t.Errorf("gotStatus=%q wantStatus=%q", report.Status, tt.wantStatus)
+			}
+			if len(report.Checks) != len(tt.wantChecks) {
+				t.Fatalf("got %d checks want %d: %s", len(report.Checks), len(tt.wantChecks), rec.Body)
+			}
+			for name, want := range tt.wantChecks {
+				res := report.Checks[name]
+				if res == nil || res.Status != want {
+					t.Errorf("%s: got=%+v want status %q", name, res, want)
+					continue
+				}
+				if (want == HealthFailing) != (res.Error != "") {
+					t.Errorf("%s: unexpected error %q", name, res.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthRunsChecksConcurrently(t *testing.T) {
+	health := new(Health)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		health.Register(HealthCheck{Name: name, Check: func(ctx context.Context) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}})
+	}
+
+	start := time.Now()
+	if report := health.Report(context.Background(), false); report.Status != HealthOK {
+		t.Fatalf("got=%q want=%q", report.Status, HealthOK)
+	}
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Fatalf("checks took %s, expected them to run concurrently", elapsed)
+	}
+}
+
+func TestHealthCachesResults(t *testing.T) {
+	var runs int32
+	health := &Health{CacheTTL: 100 * time.Millisecond}
+	health.Register(HealthCheck{Name: "db", Critical: true, Check: func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}})
+
+	for i := 0; i < 3; i++ {
+		health.Report(context.Background(), false)
+	}
+	if got := atomic.LoadInt32(&runs); got != 1 {
+		t.Fatalf("got=%d runs want=1", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	health.Report(context.Background(), false)
+	if got := atomic.LoadInt32(&runs); got != 2 {
+		t.Fatalf("after expiry got=%d runs want=2", got)
+	}
+}
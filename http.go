@@ -1,7 +1,10 @@
 package otils
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
 )
 
@@ -56,3 +59,93 @@ func MakeCodedError(msg string, code int) *CodedError {
 		code: code,
 	}
 }
+
+// AsCodedError returns err if it is or wraps a *CodedError, otherwise it
+// returns a 500 *CodedError whose message does not leak err's details.
+func AsCodedError(err error) *CodedError {
+	cerr := new(CodedError)
+	if errors.As(err, &cerr) {
+		return cerr
+	}
+	return MakeCodedError(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// codedErrorTypes are the media types that WriteCodedError can render.
+var codedErrorTypes = []string{"application/json", "application/problem+json", "text/html", "text/plain"}
+
+// WriteCodedError renders err with err's status code in the format that
+// req prefers, which defaults to the JSON response
+//
+//	{"code": 404, "error": "failed to find it", "request_id": "4bf92f35"}
+//
+// and can otherwise be an RFC 9457 application/problem+json document, an
+// HTML page or plain text. The handlers and middlewares in this package
+// use it so that all error responses look the same. Errors that are not a
+// *CodedError are rendered as a 500 Internal Server Error. The request ID
+// is only present if set by RequestIDMiddleware. Clients accepting none of
+// the formats get a 406 Not Acceptable JSON response instead. err is also
+// recorded with RecordRequestError for AccessLog.
+func WriteCodedError(rw http.ResponseWriter, req *http.Request, err error) {
+	cerr := AsCodedError(err)
+	contentType := codedErrorTypes[0]
+	var requestID string
+	if req != nil {
+		requestID = RequestIDFromContext(req.Context())
+		RecordRequestError(req, err)
+		contentType = Negotiate(req, codedErrorTypes...)
+	}
+	if contentType == "" {
+		cerr = MakeCodedError("not acceptable: "+cerr.Error(), http.StatusNotAcceptable)
+		contentType = codedErrorTypes[0]
+	}
+	code, msg := cerr.Code(), cerr.Error()
+
+	var blob []byte
+	switch contentType {
+	case "application/problem+json":
+		blob, _ = json.Marshal(&problemDetails{
+			Type:      "about:blank",
+			Title:     http.StatusText(code),
+			Status:    code,
+			Detail:    msg,
+			RequestID: requestID,
+		})
+		blob = append(blob, '\n')
+	case "text/html":
+		contentType = "text/html; charset=utf-8"
+		title := html.EscapeString(fmt.Sprintf("%d %s", code, http.StatusText(code)))
+		page := "<!doctype html>\n<title>" + title + "</title>\n<h1>" + title + "</h1>\n<p>" + html.EscapeString(msg) + "</p>\n"
+		if requestID != "" {
+			page += "<p>Request ID: <code>" + html.EscapeString(requestID) + "</code></p>\n"
+		}
+		blob = []byte(page)
+	case "text/plain":
+		contentType = "text/plain; charset=utf-8"
+		blob = []byte(msg + "\n")
+	default:
+		contentType = "application/json; charset=utf-8"
+		blob, _ = json.Marshal(&codedErrorBody{Code: code, Error: msg, RequestID: requestID})
+		blob = append(blob, '\n')
+	}
+
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Add("Vary", "Accept")
+	rw.WriteHeader(code)
+	_, _ = rw.Write(blob)
+}
+
+// problemDetails is the problem details object of RFC 9457.
+type problemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type codedErrorBody struct {
+	Code      int    `json:"code"`
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
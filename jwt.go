@@ -0,0 +1,579 @@
+package otils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTKey is a key that JWTs are verified with.
+type JWTKey struct {
+	// ID if set only lets the key verify tokens with the same "kid".
+	ID string
+
+	// Algorithm is one of "HS256", "RS256", "ES256" and "EdDSA".
+	Algorithm string
+
+	// Key is a []byte secret for HS256, an *rsa.PublicKey for RS256, an
+	// *ecdsa.PublicKey on P-256 for ES256 or an ed25519.PublicKey for EdDSA.
+	Key interface{}
+}
+
+// JWTClaims are the claims of a verified JWT.
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+
+	// Raw holds all the claims, including the registered ones above.
+	Raw map[string]interface{}
+}
+
+// Scopes returns the scopes granted by the "scope" claim, a space
+// separated string, or otherwise the "scp" claim, a list of strings.
+func (c *JWTClaims) Scopes() []string {
+	if scope, ok := c.Raw["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	var scopes []string
+	if scp, ok := c.Raw["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	}
+	return scopes
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaimsFromContext returns the claims set by JWTMiddleware, if any.
+func JWTClaimsFromContext(ctx context.Context) *JWTClaims {
+	claims, _ := ctx.Value(jwtClaimsKey{}).(*JWTClaims)
+	return claims
+}
+
+// JWKS is a JSON Web Key Set, RFC 7517, loaded from a URL or a file and
+// cached. Unknown key IDs trigger a reload so that keys can be rotated,
+// at most once per minute.
+type JWKS struct {
+	// URL of the key set, e.g. "https://id.orijtech.com/.well-known/jwks.json".
+	URL string
+
+	// File is the path of the key set, if URL is unset.
+	File string
+
+	// CacheTTL is how long keys are cached for, it defaults to 1h.
+	CacheTTL time.Duration
+
+	// HTTPClient is used to fetch URL, it defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu          sync.Mutex
+	keys        []*JWTKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	lastErr     error
+	loading     chan struct{}
+}
+
+const (
+	jwksMinRefresh   = time.Minute
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// Keys returns the keys in the set, reloading them if they are stale or
+// none has the ID kid. Reloads, successful or not, happen at most once
+// per minute and in the background, shared by all the callers waiting on
+// them, so that a slow or failing source cannot hold up every request.
+// Callers that have a key with the ID kid do not wait for reloads.
+func (ks *JWKS) Keys(ctx context.Context, kid string) ([]*JWTKey, error) {
+	ks.mu.Lock()
+	ttl := ks.CacheTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	known := ks.keys != nil && (kid == "" || hasJWTKey(ks.keys, kid))
+	stale := ks.keys == nil || time.Since(ks.fetchedAt) >= ttl || !known
+	throttled := !ks.attemptedAt.IsZero() && time.Since(ks.attemptedAt) < jwksMinRefresh
+	done := ks.loading
+	if done == nil && stale && !throttled {
+		done = make(chan struct{})
+		ks.loading = done
+		ks.attemptedAt = time.Now()
+		go ks.reload(done)
+	}
+	if known || done == nil {
+		keys, err := ks.keys, ks.lastErr
+		ks.mu.Unlock()
+		if keys == nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	ks.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.keys != nil {
+		// Possibly stale, but better than failing every
+		// request while the source is down.
+		return ks.keys, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ks.lastErr
+}
+
+func hasJWTKey(keys []*JWTKey, kid string) bool {
+	for _, key := range keys {
+		if key.ID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// reload loads the keys with a deadline of its own, since it is not
+// done on behalf of any one request, and then closes done.
+func (ks *JWKS) reload(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := ks.load(ctx)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err == nil {
+		ks.keys, ks.fetchedAt = keys, time.Now()
+	}
+	ks.lastErr = err
+	ks.loading = nil
+	close(done)
+}
+
+func (ks *JWKS) load(ctx context.Context) ([]*JWTKey, error) {
+	var blob []byte
+	var err error
+	if ks.URL != "" {
+		blob, err = ks.fetch(ctx)
+	} else {
+		blob, err = os.ReadFile(ks.File)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("otils: loading JWKS: %w", err)
+	}
+	return ParseJWKS(blob)
+}
+
+func (ks *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := ks.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if !StatusOK(res.StatusCode) {
+		return nil, fmt.Errorf("fetching %s: %s", ks.URL, res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// ParseJWKS parses the signing keys of a JSON Web Key Set, skipping the
+// keys that are meant for encryption or of an unsupported type.
+func ParseJWKS(blob []byte) ([]*JWTKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(blob, &set); err != nil {
+		return nil, fmt.Errorf("otils: invalid JWKS: %w", err)
+	}
+
+	var keys []*JWTKey
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key := &JWTKey{ID: jwk.Kid}
+		var err error
+		switch {
+		case jwk.Kty == "RSA":
+			key.Algorithm = "RS256"
+			key.Key, err = parseRSAJWK(jwk.N, jwk.E)
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			key.Algorithm = "ES256"
+			key.Key, err = parseECJWK(jwk.X, jwk.Y)
+		case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+			key.Algorithm = "EdDSA"
+			var x []byte
+			if x, err = base64.RawURLEncoding.DecodeString(jwk.X); err == nil && len(x) != ed25519.PublicKeySize {
+				err = errors.New("wrong key size")
+			}
+			key.Key = ed25519.PublicKey(x)
+		case jwk.Kty == "oct":
+			key.Algorithm = "HS256"
+			key.Key, err = base64.RawURLEncoding.DecodeString(jwk.K)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("otils: invalid JWK %q: %w", jwk.Kid, err)
+		}
+		if jwk.Alg != "" && jwk.Alg != key.Algorithm {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseRSAJWK(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if len(nb) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exponent.Int64())}, nil
+}
+
+func parseECJWK(x, y string) (*ecdsa.PublicKey, error) {
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("point is not on P-256")
+	}
+	return pub, nil
+}
+
+// JWT is a middleware that verifies the JWTs that requests carry as bearer
+// tokens, RFC 7519, storing their claims in the request's context. Tokens
+// must be signed with HS256, RS256, ES256 or EdDSA by one of Keys or of
+// the keys in JWKS, and be valid for Issuer and Audience at the current
+// time give or take ClockSkew, otherwise the request fails with a 401
+// *CodedError. Tokens lacking the RequiredScopes fail with a 403 one.
+// Sample usage is:
+//
+//	handler := otils.JWTMiddleware(&otils.JWT{
+//		JWKS:     &otils.JWKS{URL: "https://id.orijtech.com/.well-known/jwks.json"},
+//		Issuer:   "https://id.orijtech.com",
+//		Audience: "billing",
+//	}, mux)
+//
+// after which handlers retrieve the claims with JWTClaimsFromContext and
+// the subject with PrincipalFromContext.
+type JWT struct {
+	Keys []*JWTKey
+	JWKS *JWKS
+
+	// Issuer if set must be the "iss" claim of tokens.
+	Issuer string
+
+	// Audience if set must be one of the "aud" claims of tokens.
+	Audience string
+
+	// ClockSkew is the leeway given to time based claims, it defaults to 1m.
+	ClockSkew time.Duration
+
+	// RequiredScopes must all be granted by tokens, see JWTClaims.Scopes.
+	RequiredScopes []string
+
+	// Realm is sent in WWW-Authenticate challenges.
+	Realm string
+
+	next http.Handler
+	now  func() time.Time
+}
+
+// JWTMiddleware returns a handler that verifies the JWTs of requests
+// before passing them on to next. A nil JWT lets all requests through.
+func JWTMiddleware(j *JWT, next http.Handler) http.Handler {
+	if j == nil {
+		return next
+	}
+	copy := new(JWT)
+	*copy = *j
+	copy.next = next
+	return copy
+}
+
+var _ http.Handler = (*JWT)(nil)
+
+func (j *JWT) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if isPreflight(req) {
+		j.serveNext(rw, req)
+		return
+	}
+
+	token, ok := bearerToken(req)
+	if !ok {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", j.Realm))
+		WriteCodedError(rw, req, MakeCodedError("authentication required", http.StatusUnauthorized))
+		return
+	}
+	claims, err := j.Verify(req.Context(), token)
+	if err != nil {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\", error_description=%q", j.Realm, err.Error()))
+		WriteCodedError(rw, req, MakeCodedError(err.Error(), http.StatusUnauthorized))
+		return
+	}
+	if missing := missingScopes(claims.Scopes(), j.RequiredScopes); len(missing) > 0 {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q, error=\"insufficient_scope\", scope=%q", j.Realm, strings.Join(j.RequiredScopes, " ")))
+		WriteCodedError(rw, req, MakeCodedError("token lacks scopes: "+strings.Join(missing, ", "), http.StatusForbidden))
+		return
+	}
+
+	ctx := context.WithValue(req.Context(), jwtClaimsKey{}, claims)
+	ctx = ContextWithPrincipal(ctx, &Principal{Name: claims.Subject, Scheme: "Bearer"})
+	j.serveNext(rw, req.WithContext(ctx))
+}
+
+func (j *JWT) serveNext(rw http.ResponseWriter, req *http.Request) {
+	if j.next != nil {
+		j.next.ServeHTTP(rw, req)
+	}
+}
+
+func missingScopes(granted, required []string) []string {
+	var missing []string
+	for _, scope := range required {
+		found := false
+		for _, g := range granted {
+			if g == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// Verify checks the signature and claims of token and returns its
+// claims. Its errors are fit to be shown to clients.
+func (j *JWT) Verify(ctx context.Context, token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	keys := j.Keys
+	if j.JWKS != nil {
+		jwksKeys, err := j.JWKS.Keys(ctx, header.Kid)
+		if err != nil {
+			return nil, errors.New("signing keys are unavailable")
+		}
+		keys = append(append([]*JWTKey(nil), keys...), jwksKeys...)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		// Only the key's own algorithm is used with it, so that
+		// e.g. a public RSA key is never taken for an HMAC secret.
+		if key.Algorithm != header.Alg || (header.Kid != "" && key.ID != "" && key.ID != header.Kid) {
+			continue
+		}
+		if verifyJWTSignature(key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid token signature")
+	}
+
+	claims := new(JWTClaims)
+	if err := decodeJWTPart(parts[1], &claims.Raw); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if err := claims.parseRegistered(); err != nil {
+		return nil, err
+	}
+	return claims, j.validate(claims)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	blob, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(blob, v)
+}
+
+func verifyJWTSignature(key *JWTKey, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch k := key.Key.(type) {
+	case []byte:
+		if key.Algorithm != "HS256" || len(k) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		return key.Algorithm == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if key.Algorithm != "ES256" || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	case ed25519.PublicKey:
+		return key.Algorithm == "EdDSA" && ed25519.Verify(k, signed, sig)
+	default:
+		return false
+	}
+}
+
+func (c *JWTClaims) parseRegistered() error {
+	var ok bool
+	if v, present := c.Raw["iss"]; present {
+		if c.Issuer, ok = v.(string); !ok {
+			return errors.New(`invalid "iss" claim`)
+		}
+	}
+	if v, present := c.Raw["sub"]; present {
+		if c.Subject, ok = v.(string); !ok {
+			return errors.New(`invalid "sub" claim`)
+		}
+	}
+	if v, present := c.Raw["jti"]; present {
+		if c.ID, ok = v.(string); !ok {
+			return errors.New(`invalid "jti" claim`)
+		}
+	}
+	switch aud := c.Raw["aud"].(type) {
+	case nil:
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			str, ok := a.(string)
+			if !ok {
+				return errors.New(`invalid "aud" claim`)
+			}
+			c.Audience = append(c.Audience, str)
+		}
+	default:
+		return errors.New(`invalid "aud" claim`)
+	}
+	for name, dst := range map[string]*time.Time{"exp": &c.ExpiresAt, "nbf": &c.NotBefore, "iat": &c.IssuedAt} {
+		v, present := c.Raw[name]
+		if !present {
+			continue
+		}
+		secs, ok := v.(float64)
+		if !ok || !(secs >= 0 && secs <= maxNumericDate) {
+			return fmt.Errorf("invalid %q claim", name)
+		}
+		whole, frac := math.Modf(secs)
+		*dst = time.Unix(int64(whole), int64(frac*1e9))
+	}
+	return nil
+}
+
+// maxNumericDate is 9999-12-31T23:59:59Z, the latest NumericDate accepted
+// so that times stay representable and comparable. NaN and infinities
+// are out of range too.
+const maxNumericDate = 253402300799
+
+func (j *JWT) validate(claims *JWTClaims) error {
+	now := time.Now()
+	if j.now != nil {
+		now = j.now()
+	}
+	skew := j.ClockSkew
+	if skew <= 0 {
+		skew = time.Minute
+	}
+	if !claims.ExpiresAt.IsZero() && now.After(claims.ExpiresAt.Add(skew)) {
+		return errors.New("token has expired")
+	}
+	if !claims.NotBefore.IsZero() && now.Before(claims.NotBefore.Add(-skew)) {
+		return errors.New("token is not valid yet")
+	}
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return errors.New("token has the wrong issuer")
+	}
+	if j.Audience != "" {
+		for _, aud := range claims.Audience {
+			if aud == j.Audience {
+				return nil
+			}
+		}
+		return errors.New("token has the wrong audience")
+	}
+	return nil
+}
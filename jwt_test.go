@@ -0,0 +1,405 @@
+package otils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	hblob, _ := json.Marshal(header)
+	cblob, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hblob) + "." + base64.RawURLEncoding.EncodeToString(cblob)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	secret := []byte("orijtech-hs256-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://id.orijtech.com",
+			"sub":   "user-1",
+			"aud":   []string{"billing", "orders"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"nbf":   now.Add(-time.Hour).Unix(),
+			"scope": "invoices:read invoices:write",
+		}
+		for key, value := range overrides {
+			if value == nil {
+				delete(c, key)
+			} else {
+				c[key] = value
+			}
+		}
+		return c
+	}
+
+	j := &JWT{
+		Keys: []*JWTKey{
+			{Algorithm: "HS256", Key: secret},
+			{ID: "rsa-1", Algorithm: "RS256", Key: &rsaKey.PublicKey},
+			{Algorithm: "ES256", Key: &ecKey.PublicKey},
+			{Algorithm: "EdDSA", Key: edPub},
+		},
+		Issuer:         "https://id.orijtech.com",
+		Audience:       "billing",
+		RequiredScopes: []string{"invoices:read"},
+		Realm:          "otils",
+		now:            func() time.Time { return now },
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantMsg  string
+	}{
+		{name: "HS256", token: signTestJWT(t, "HS256", "", secret, claims(nil)), wantCode: http.StatusOK},
+		{name: "RS256", token: signTestJWT(t, "RS256", "rsa-1", rsaKey, claims(nil)), wantCode: http.StatusOK},
+		{name: "ES256", token: signTestJWT(t, "ES256", "", ecKey, claims(nil)), wantCode: http.StatusOK},
+		{name: "EdDSA", token: signTestJWT(t, "EdDSA", "", edKey, claims(nil)), wantCode: http.StatusOK},
+		{
+			name:     "missing token",
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "authentication required",
+		},
+		{
+			name:     "wrong kid",
+			token:    signTestJWT(t, "RS256", "rsa-2", rsaKey, claims(nil)),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid token signature",
+		},
+		{
+			name:     "wrong secret",
+			token:    signTestJWT(t, "HS256", "", []byte("guess"), claims(nil)),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid token signature",
+		},
+		{
+			name: "algorithm confusion",
+			// The RSA public key must not be usable as an HMAC secret.
+			token:    signTestJWT(t, "HS256", "rsa-1", rsaKey.PublicKey.N.Bytes(), claims(nil)),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid token signature",
+		},
+		{
+			name:     "alg none",
+			token:    signTestJWT(t, "none", "", []byte("x"), claims(nil)),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "invalid token signature",
+		},
+		{
+			name:     "malformed",
+			token:    "not.a-jwt",
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "malformed token",
+		},
+		{
+			name:     "expired",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "token has expired",
+		},
+		{
+			name:     "expired within clock skew",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "not valid yet",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()})),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "token is not valid yet",
+		},
+		{
+			name:     "far future expiry",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"exp": 1e10})),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "far future not before",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"nbf": 1e10})),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "token is not valid yet",
+		},
+		{
+			name:     "fractional not before",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"nbf": float64(now.Add(2*time.Minute).Unix()) + 0.5})),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "token is not valid yet",
+		},
+		{
+			name:     "out of range expiry",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"exp": 1e300})),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  `invalid "exp" claim`,
+		},
+		{
+			name:     "wrong issuer",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"iss": "https://evil.example"})),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "token has the wrong issuer",
+		},
+		{
+			name:     "single audience",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"aud": "billing"})),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "wrong audience",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"aud": "orders"})),
+			wantCode: http.StatusUnauthorized,
+			wantMsg:  "token has the wrong audience",
+		},
+		{
+			name:     "missing scope",
+			token:    signTestJWT(t, "HS256", "", secret, claims(map[string]interface{}{"scope": nil, "scp": []string{"orders:read"}})),
+			wantCode: http.StatusForbidden,
+			wantMsg:  "token lacks scopes: invoices:read",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotClaims *JWTClaims
+			var gotPrincipal *Principal
+			handler := JWTMiddleware(j, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				gotClaims = JWTClaimsFromContext(req.Context())
+				gotPrincipal = PrincipalFromContext(req.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/invoices", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d body=%s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusOK {
+				if gotClaims == nil || gotClaims.Subject != "user-1" || gotPrincipal == nil || gotPrincipal.Name != "user-1" {
+					t.Fatalf("unexpected claims %+v and principal %+v", gotClaims, gotPrincipal)
+				}
+				return
+			}
+			var body codedErrorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != tt.wantMsg {
+				t.Fatalf("got=%q want=%q", rec.Body, tt.wantMsg)
+			}
+			if challenge := rec.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, `Bearer realm="otils"`) {
+				t.Fatalf("unexpected challenge %q", challenge)
+			}
+		})
+	}
+}
+
+func jwksFor(t *testing.T, kid string, pub *ecdsa.PublicKey) []byte {
+	blob, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "use": "enc", "kid": "enc-1", "n": "AQAB", "e": "AQAB"},
+			{
+				"kty": "EC",
+				"crv": "P-256",
+				"kid": kid,
+				"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+				"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+func TestJWKS(t *testing.T) {
+	key1, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key2, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var current atomic.Value
+	current.Store(jwksFor(t, "k1", &key1.PublicKey))
+	var fetches int32
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = rw.Write(current.Load().([]byte))
+	}))
+	defer tst.Close()
+
+	jwks := &JWKS{URL: tst.URL}
+	j := &JWT{JWKS: jwks}
+	claims := map[string]interface{}{"sub": "user-1"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := j.Verify(context.Background(), signTestJWT(t, "ES256", "k1", key1, claims)); err != nil {
+			t.Fatalf("#%d: unexpected err: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("got=%d fetches want=1", got)
+	}
+
+	// A rotated key is picked up once the minimum refresh interval passed.
+	current.Store(jwksFor(t, "k2", &key2.PublicKey))
+	token := signTestJWT(t, "ES256", "k2", key2, claims)
+	if _, err := j.Verify(context.Background(), token); err == nil {
+		t.Fatal("expected the unknown key to be rejected before the refresh interval")
+	}
+	jwks.mu.Lock()
+	jwks.fetchedAt = jwks.fetchedAt.Add(-2 * jwksMinRefresh)
+	jwks.attemptedAt = jwks.attemptedAt.Add(-2 * jwksMinRefresh)
+	jwks.mu.Unlock()
+	if _, err := j.Verify(context.Background(), token); err != nil {
+		t.Fatalf("unexpected err after rotation: %v", err)
+	}
+	if got := atomic.LoadInt32(&fetches); got != 2 {
+		t.Fatalf("got=%d fetches want=2", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksFor(t, "k1", &key1.PublicKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fromFile := &JWT{JWKS: &JWKS{File: path}}
+	if _, err := fromFile.Verify(context.Background(), signTestJWT(t, "ES256", "k1", key1, claims)); err != nil {
+		t.Fatalf("unexpected err with a JWKS file: %v", err)
+	}
+}
+
+func TestJWKSFailingSource(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches int32
+	var failing atomic.Bool
+	failing.Store(true)
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if failing.Load() {
+			time.Sleep(50 * time.Millisecond)
+			http.Error(rw, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write(jwksFor(t, "k1", &key.PublicKey))
+	}))
+	defer tst.Close()
+
+	jwks := &JWKS{URL: tst.URL}
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := jwks.Keys(context.Background(), "k1"); err == nil {
+			t.Fatalf("#%d: expected an error while the source is down", i)
+		}
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("failures must be throttled too, got=%d fetches want=1", got)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("lookups queued behind the failing source, took %s", elapsed)
+	}
+
+	// Callers do not wait past their own deadline for a slow source.
+	jwks.mu.Lock()
+	jwks.attemptedAt = time.Time{}
+	jwks.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := jwks.Keys(ctx, "k1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Once the source recovers, the keys are picked up after the interval.
+	failing.Store(false)
+	for {
+		jwks.mu.Lock()
+		loading := jwks.loading != nil
+		jwks.attemptedAt = time.Time{}
+		jwks.mu.Unlock()
+		if !loading {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	keys, err := jwks.Keys(context.Background(), "k1")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected the recovered keys, got %v, %v", keys, err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	tests := []struct {
+		jwks     string
+		wantKeys int
+		wantErr  bool
+	}{
+		0: {jwks: `{"keys":[]}`},
+		1: {jwks: `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`, wantKeys: 1},
+		2: {jwks: `{"keys":[{"kty":"oct","k":"c2VjcmV0","alg":"HS512"}]}`},
+		3: {jwks: `{"keys":[{"kty":"EC","crv":"P-384","x":"AA","y":"AA"}]}`},
+		4: {jwks: `{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`, wantErr: true},
+		5: {jwks: `{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AQ"}]}`, wantErr: true},
+		6: {jwks: `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`, wantErr: true},
+		7: {jwks: `{"keys":`, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		keys, err := ParseJWKS([]byte(tt.jwks))
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("#%d gotErr=%v wantErr=%t", i, err, tt.wantErr)
+			continue
+		}
+		if len(keys) != tt.wantKeys {
+			t.Errorf("#%d got=%d keys want=%d", i, len(keys), tt.wantKeys)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MaintenanceRule selects the requests that are in maintenance. Unset
+// fields match any request, so the zero MaintenanceRule puts the whole
+// service in maintenance.
+type MaintenanceRule struct {
+	// PathPrefix matches requests whose path starts with it.
+	PathPrefix string `json:"path_prefix,omitempty"`
+
+	// Methods matches requests with any of these methods.
+	Methods []string `json:"methods,omitempty"`
+
+	// Host matches requests for this host, ignoring any port.
+	Host string `json:"host,omitempty"`
+
+	// Message if set replaces the default error message.
+	Message string `json:"message,omitempty"`
+}
+
+// Matches reports whether req is selected by the rule.
+func (rule *MaintenanceRule) Matches(req *http.Request) bool {
+	if rule.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, rule.PathPrefix) {
+		return false
+	}
+	if len(rule.Methods) > 0 && !MatchMethods(rule.Methods...)(req) {
+		return false
+	}
+	if rule.Host != "" {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, rule.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+// MaintenanceRules is a set of rules that is swapped as a whole.
+type MaintenanceRules struct {
+	Rules []*MaintenanceRule `json:"rules"`
+
+	// RetryAfter is the number of seconds clients are told to wait
+	// before retrying, it defaults to 120.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// match returns the first rule that selects req, if any.
+func (mr *MaintenanceRules) match(req *http.Request) *MaintenanceRule {
+	if mr == nil {
+		return nil
+	}
+	for _, rule := range mr.Rules {
+		if rule != nil && rule.Matches(req) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// ParseMaintenanceRules parses rules from either their JSON form, or a
+// shorthand convenient for environment variables: "on" for the whole
+// service, "off" or "" for none of it, or a comma separated list of path
+// prefixes such as "/billing,/api/v1/exports".
+func ParseMaintenanceRules(value string) (*MaintenanceRules, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		rules := new(MaintenanceRules)
+		if err := json.Unmarshal([]byte(value), rules); err != nil {
+			return nil, fmt.Errorf("otils: invalid maintenance rules: %w", err)
+		}
+		for i, rule := range rules.Rules {
+			if rule == nil {
+				return nil, fmt.Errorf("otils: invalid maintenance rules: rule #%d is null", i)
+			}
+		}
+		return rules, nil
+	}
+
+	switch strings.ToLower(value) {
+	case "", "off", "false", "0":
+		return new(MaintenanceRules), nil
+	case "on", "true", "1":
+		return &MaintenanceRules{Rules: []*MaintenanceRule{{}}}, nil
+	}
+	rules := new(MaintenanceRules)
+	for _, prefix := range strings.Split(value, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("otils: invalid maintenance path prefix %q", prefix)
+		}
+		rules.Rules = append(rules.Rules, &MaintenanceRule{PathPrefix: prefix})
+	}
+	return rules, nil
+}
+
+// MaintenanceMode holds the MaintenanceRules in effect, which can be
+// swapped atomically while requests are being served. Its zero value has
+// no rules in effect.
+type MaintenanceMode struct {
+	rules atomic.Pointer[MaintenanceRules]
+}
+
+// Set puts rules in effect, a nil rules ends maintenance.
+func (mm *MaintenanceMode) Set(rules *MaintenanceRules) {
+	mm.rules.Store(rules)
+}
+
+// Rules returns the rules in effect, which must not be modified.
+func (mm *MaintenanceMode) Rules() *MaintenanceRules {
+	return mm.rules.Load()
+}
+
+// LoadEnv puts the rules in envVar, or otherwise the first non-blank
+// alternate as with EnvOrAlternates, in effect.
+// See ParseMaintenanceRules for their format.
+func (mm *MaintenanceMode) LoadEnv(envVar string, alternates ...string) error {
+	rules, err := ParseMaintenanceRules(EnvOrAlternates(envVar, alternates...))
+	if err != nil {
+		return err
+	}
+	mm.Set(rules)
+	return nil
+}
+
+// LoadFile puts the rules in the file at path in effect.
+// See ParseMaintenanceRules for their format.
+func (mm *MaintenanceMode) LoadFile(path string) error {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules, err := ParseMaintenanceRules(string(blob))
+	if err != nil {
+		return fmt.Errorf("%w in %s", err, path)
+	}
+	mm.Set(rules)
+	return nil
+}
+
+// WatchFile reloads the rules from the file at path every time its
+// modification time changes, checking every interval, or every 5s if it
+// is not positive, until ctx is done. Errors leave the rules in effect
+// unchanged and are passed to onError if it is set.
+func (mm *MaintenanceMode) WatchFile(ctx context.Context, path string, interval time.Duration, onError func(error)) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	var lastMod time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fi, err := os.Stat(path)
+		if err == nil && !fi.ModTime().Equal(lastMod) {
+			if err = mm.LoadFile(path); err == nil {
+				lastMod = fi.ModTime()
+			}
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintenance is a middleware that answers the requests selected by the
+// rules in effect in Mode with a 503 *CodedError and a Retry-After
+// header, or with Page for browsers. Requests matched by Exempt, such as
+// health checks, and requests from AdminIPs are always let through.
+// Sample usage is:
+//
+//	mode := new(otils.MaintenanceMode)
+//	if err := mode.LoadEnv("MAINTENANCE"); err != nil {
+//		log.Fatal(err)
+//	}
+//	handler := otils.MaintenanceMiddleware(&otils.Maintenance{
+//		Mode:   mode,
+//		Exempt: otils.MatchPathPrefix("/healthz", "/readyz"),
+//	}, mux)
+//
+// after which MAINTENANCE="/billing" puts all of /billing in maintenance
+// and mode.Set or mode.WatchFile change the rules without a redeploy.
+type Maintenance struct {
+	Mode *MaintenanceMode
+
+	// Exempt matches requests that are never in maintenance.
+	Exempt RequestMatcher
+
+	// AdminIPs are networks whose requests are never in maintenance.
+	AdminIPs []*net.IPNet
+
+	// ClientIP determines the client's address to compare against AdminIPs,
+	// if nil the address the request came from is used.
+	ClientIP *ClientIPResolver
+
+	// Page if set is the HTML page served to clients that prefer HTML.
+	Page []byte
+
+	next http.Handler
+}
+
+// MaintenanceMiddleware returns a handler that only passes the requests
+// that are not in maintenance on to next. A nil Maintenance or one without
+// a Mode never puts requests in maintenance.
+func MaintenanceMiddleware(m *Maintenance, next http.Handler) http.Handler {
+	if m == nil || m.Mode == nil {
+		return next
+	}
+	copy := new(Maintenance)
+	*copy = *m
+	copy.next = next
+	return copy
+}
+
+var _ http.Handler = (*Maintenance)(nil)
+
+func (m *Maintenance) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rules := m.Mode.Rules()
+	rule := rules.match(req)
+	if rule == nil || (m.Exempt != nil && m.Exempt(req)) || ipInNets(m.ClientIP.ClientIP(req), m.AdminIPs) {
+		if m.next != nil {
+			m.next.ServeHTTP(rw, req)
+		}
+		return
+	}
+
+	retryAfter := rules.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = 120
+	}
+	rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	rw.Header().Set("Cache-Control", "no-store")
+	if len(m.Page) > 0 && Negotiate(req, "application/json", "text/html") == "text/html" {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write(m.Page)
+		return
+	}
+	msg := rule.Message
+	if msg == "" {
+		msg = "service is under maintenance"
+	}
+	WriteCodedError(rw, req, MakeCodedError(msg, http.StatusServiceUnavailable))
+}
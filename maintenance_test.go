@@ -0,0 +1,233 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceRules(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		0: {value: "", want: `{"rules":null}`},
+		1: {value: "off", want: `{"rules":null}`},
+		2: {value: " ON ", want: `{"rules":[{}]}`},
+		3: {value: "/billing, /exports", want: `{"rules":[{"path_prefix":"/billing"},{"path_prefix":"/exports"}]}`},
+		4: {
+			value: `{"rules":[{"methods":["POST"],"host":"api.orijtech.com"}],"retry_after":600}`,
+			want:  `{"rules":[{"methods":["POST"],"host":"api.orijtech.com"}],"retry_after":600}`,
+		},
+		5: {value: "billing", wantErr: true},
+		6: {value: `{"rules":`, wantErr: true},
+		7: {value: `{"rules":[null]}`, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		rules, err := ParseMaintenanceRules(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("#%d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected err: %v", i, err)
+			continue
+		}
+		blob, _ := json.Marshal(rules)
+		if string(blob) != tt.want {
+			t.Errorf("#%d got=%s want=%s", i, blob, tt.want)
+		}
+	}
+}
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	admins, _ := ParseCIDRs("198.51.100.0/24")
+	mode := new(MaintenanceMode)
+	handler := MaintenanceMiddleware(&Maintenance{
+		Mode:     mode,
+		Exempt:   MatchPathPrefix("/healthz"),
+		AdminIPs: admins,
+		Page:     []byte("<h1>Back soon</h1>"),
+	}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+
+	rules := &MaintenanceRules{
+		Rules: []*MaintenanceRule{
+			{PathPrefix: "/billing", Message: "billing is being migrated"},
+			{Methods: []string{"POST", "DELETE"}, Host: "api.orijtech.com"},
+		},
+		RetryAfter: 600,
+	}
+
+	tests := []struct {
+		name       string
+		rules      *MaintenanceRules
+		method     string
+		url        string
+		remoteAddr string
+		accept     string
+		wantCode   int
+		wantBody   string
+	}{
+		{
+			name:     "no rules",
+			url:      "/billing",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "path in maintenance",
+			rules:    rules,
+			url:      "/billing/invoices",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"code":503,"error":"billing is being migrated"}`,
+		},
+		{
+			name:     "other path",
+			rules:    rules,
+			url:      "/orders",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "method and host in maintenance",
+			rules:    rules,
+			method:   http.MethodPost,
+			url:      "http://api.orijtech.com:8080/orders",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"code":503,"error":"service is under maintenance"}`,
+		},
+		{
+			name:     "method on another host",
+			rules:    rules,
+			method:   http.MethodPost,
+			url:      "http://orijtech.com/orders",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "health checks are exempt",
+			rules:    &MaintenanceRules{Rules: []*MaintenanceRule{{}}},
+			url:      "/healthz",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:       "admins are exempt",
+			rules:      &MaintenanceRules{Rules: []*MaintenanceRule{{}}},
+			url:        "/billing",
+			remoteAddr: "198.51.100.10:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:     "page for browsers",
+			rules:    &MaintenanceRules{Rules: []*MaintenanceRule{{}}},
+			url:      "/",
+			accept:   "text/html,application/xhtml+xml;q=0.9",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: "<h1>Back soon</h1>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode.Set(tt.rules)
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.url, nil)
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK {
+				return
+			}
+			wantRetryAfter := "120"
+			if tt.rules.RetryAfter != 0 {
+				wantRetryAfter = "600"
+			}
+			if got := rec.Header().Get("Retry-After"); got != wantRetryAfter {
+				t.Errorf("Retry-After: got=%q want=%q", got, wantRetryAfter)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("gotBody=%q wantBody=%q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMaintenanceModeLoad(t *testing.T) {
+	mode := new(MaintenanceMode)
+	t.Setenv("OTILS_MAINTENANCE", "")
+	if err := mode.LoadEnv("OTILS_MAINTENANCE", "", "/exports"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if rules := mode.Rules(); len(rules.Rules) != 1 || rules.Rules[0].PathPrefix != "/exports" {
+		t.Fatalf("expected the alternate to be used, got %+v", rules)
+	}
+
+	t.Setenv("OTILS_MAINTENANCE", "on")
+	if err := mode.LoadEnv("OTILS_MAINTENANCE", "/exports"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if rules := mode.Rules(); len(rules.Rules) != 1 || rules.Rules[0].PathPrefix != "" {
+		t.Fatalf("expected the whole service in maintenance, got %+v", rules)
+	}
+
+	t.Setenv("OTILS_MAINTENANCE", "billing")
+	if err := mode.LoadEnv("OTILS_MAINTENANCE"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(mode.Rules().Rules) != 1 {
+		t.Fatal("a failed load must keep the rules in effect")
+	}
+
+	path := filepath.Join(t.TempDir(), "maintenance.json")
+	if err := os.WriteFile(path, []byte(`{"rules":[{"path_prefix":"/billing"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mode.WatchFile(ctx, path, 10*time.Millisecond, nil)
+
+	waitForRules := func(want string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if rules := mode.Rules(); rules != nil && len(rules.Rules) > 0 && rules.Rules[0].PathPrefix == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("rules for %q were not loaded", want)
+	}
+	waitForRules("/billing")
+
+	future := time.Now().Add(time.Hour)
+	if err := os.WriteFile(path, []byte("/exports"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	waitForRules("/exports")
+
+	// A non-positive interval uses the default rather than panicking.
+	done, stop := context.WithCancel(context.Background())
+	stop()
+	mode.WatchFile(done, path, 0, nil)
+}
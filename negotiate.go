@@ -0,0 +1,225 @@
+package otils
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AcceptSpec is a range of an Accept, Accept-Language or Accept-Encoding
+// header, such as "text/*", "en-GB" or "gzip", along with its weight.
+type AcceptSpec struct {
+	// Value is the range, with "*" or "*/*" for anything.
+	Value string
+
+	// Params are the media type parameters of the range other than q.
+	Params map[string]string
+
+	// Q is the weight between 0 and 1, with 0 refusing what it matches.
+	Q float64
+}
+
+// ParseAccept parses the values of an Accept, Accept-Language or
+// Accept-Encoding header, ordered from the most to the least preferred.
+// Ranges of equal weight keep their order, and those with a weight of
+// zero are kept as they refuse what they match.
+func ParseAccept(values ...string) []*AcceptSpec {
+	var specs []*AcceptSpec
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if spec := parseAcceptElement(element); spec != nil {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	sort.SliceStable(specs, func(i, j int) bool { return specs[i].Q > specs[j].Q })
+	return specs
+}
+
+// parseAcceptElement parses an element like "text/html;level=1;q=0.5",
+// with a malformed q value counting as 0.
+func parseAcceptElement(element string) *AcceptSpec {
+	value, params, _ := strings.Cut(element, ";")
+	spec := &AcceptSpec{Value: strings.TrimSpace(value), Q: 1}
+	if spec.Value == "" {
+		return nil
+	}
+	for _, param := range strings.Split(params, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+		if key != "q" {
+			if spec.Params == nil {
+				spec.Params = make(map[string]string)
+			}
+			spec.Params[key] = val
+			continue
+		}
+		if parsed, err := strconv.ParseFloat(val, 64); err == nil && parsed >= 0 && parsed <= 1 {
+			spec.Q = parsed
+		} else {
+			spec.Q = 0
+		}
+	}
+	return spec
+}
+
+// Negotiate returns the media type among offers that is most preferred
+// by the Accept header of req, ties going to the earliest offer. Without
+// an Accept header the first offer is returned. It returns "" if none of
+// the offers is acceptable, to which servers usually respond with 406 Not
+// Acceptable.
+// Sample usage is:
+//
+//	switch otils.Negotiate(req, "application/json", "text/csv") {
+//	case "text/csv":
+//		writeCSV(rw, report)
+//	case "application/json":
+//		writeJSON(rw, report)
+//	default:
+//		otils.WriteCodedError(rw, req, otils.MakeCodedError("not acceptable", http.StatusNotAcceptable))
+//	}
+func Negotiate(req *http.Request, offers ...string) string {
+	specs := ParseAccept(req.Header.Values("Accept")...)
+	if len(specs) == 0 {
+		return firstOffer(offers)
+	}
+	return negotiate(specs, offers, matchMediaRange, nil)
+}
+
+// NegotiateLanguage returns the language tag among offers that is most
+// preferred by the Accept-Language header of req, a range like "en"
+// matching both "en" and "en-GB". Without an Accept-Language header the
+// first offer is returned, and it returns "" if none is acceptable.
+func NegotiateLanguage(req *http.Request, offers ...string) string {
+	specs := ParseAccept(req.Header.Values("Accept-Language")...)
+	if len(specs) == 0 {
+		return firstOffer(offers)
+	}
+	return negotiate(specs, offers, matchLanguageRange, nil)
+}
+
+// NegotiateEncoding returns the content coding among offers, such as
+// "gzip" or "identity", that is most preferred by the Accept-Encoding
+// header of req. The "identity" coding is acceptable unless refused, but
+// least preferred, which is all that is acceptable for requests without an
+// Accept-Encoding header. It returns "" if none of the offers is acceptable.
+func NegotiateEncoding(req *http.Request, offers ...string) string {
+	specs := ParseAccept(req.Header.Values("Accept-Encoding")...)
+	return negotiate(specs, offers, matchEncoding, func(offer string) float64 {
+		if strings.EqualFold(offer, "identity") {
+			// Below any coding that is explicitly accepted.
+			return 0.001
+		}
+		return 0
+	})
+}
+
+func firstOffer(offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0]
+}
+
+// negotiate returns the offer with the highest weight, given by the most
+// specific of specs that matches it, ties going to the earliest offer.
+// match returns how specific a range is, or -1 if it does not match the
+// offer, and unmatchedQ if set weighs the offers that no range matches.
+func negotiate(specs []*AcceptSpec, offers []string, match func(*AcceptSpec, string) int, unmatchedQ func(string) float64) string {
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, spec := range specs {
+			if s := match(spec, offer); s > specificity {
+				q, specificity = spec.Q, s
+			}
+		}
+		if specificity < 0 && unmatchedQ != nil {
+			q = unmatchedQ(offer)
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// matchMediaRange matches ranges like "*/*", "text/*" and
+// "text/html;level=1" against media types like "text/html".
+func matchMediaRange(spec *AcceptSpec, offer string) int {
+	offerType, offerParams, _ := strings.Cut(offer, ";")
+	typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(offerType)), "/")
+	if !ok {
+		return -1
+	}
+	rangeType, rangeSubtype, ok := strings.Cut(strings.ToLower(spec.Value), "/")
+	if !ok {
+		// Some clients send a lone "*".
+		if spec.Value != "*" {
+			return -1
+		}
+		rangeType, rangeSubtype = "*", "*"
+	}
+
+	switch {
+	case rangeType == "*" && rangeSubtype == "*":
+		return 0
+	case rangeType != typ:
+		return -1
+	case rangeSubtype == "*":
+		return 1
+	case rangeSubtype != subtype:
+		return -1
+	}
+	if len(spec.Params) == 0 {
+		return 2
+	}
+	offered := parseAcceptElement(offerType + ";" + offerParams).Params
+	for key, val := range spec.Params {
+		if !strings.EqualFold(offered[key], val) {
+			return -1
+		}
+	}
+	return 2 + len(spec.Params)
+}
+
+// matchLanguageRange matches language ranges against language tags with
+// the basic filtering of RFC 4647, longer ranges being more specific.
+func matchLanguageRange(spec *AcceptSpec, offer string) int {
+	switch {
+	case spec.Value == "*":
+		return 0
+	case strings.EqualFold(spec.Value, offer):
+		return len(spec.Value)
+	case len(offer) > len(spec.Value) && offer[len(spec.Value)] == '-' &&
+		strings.EqualFold(offer[:len(spec.Value)], spec.Value):
+		return len(spec.Value)
+	default:
+		return -1
+	}
+}
+
+// matchEncoding matches content codings, "x-gzip" being an alias of "gzip".
+func matchEncoding(spec *AcceptSpec, offer string) int {
+	coding := strings.ToLower(spec.Value)
+	if coding == "x-gzip" {
+		coding = "gzip"
+	}
+	offer = strings.ToLower(offer)
+	if offer == "x-gzip" {
+		offer = "gzip"
+	}
+	switch coding {
+	case "*":
+		return 0
+	case offer:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,120 @@
+package otils
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+	specs := ParseAccept(`text/html;q=0.8, application/json, text/plain;format="flowed";q=0.8`, "*/*;q=0.1, image/png;q=bogus")
+	want := []*AcceptSpec{
+		{Value: "application/json", Q: 1},
+		{Value: "text/html", Q: 0.8},
+		{Value: "text/plain", Params: map[string]string{"format": "flowed"}, Q: 0.8},
+		{Value: "*/*", Q: 0.1},
+		{Value: "image/png", Q: 0},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		for i, spec := range specs {
+			t.Logf("#%d %+v", i, spec)
+		}
+		t.Fatal("unexpected specs")
+	}
+	if got := ParseAccept("", " , "); len(got) != 0 {
+		t.Fatalf("expected no specs, got %d", len(got))
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/html", "text/plain"}
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		0:  {accept: "", want: "application/json"},
+		1:  {accept: "*/*", want: "application/json"},
+		2:  {accept: "text/html", want: "text/html"},
+		3:  {accept: "text/*", want: "text/html"},
+		4:  {accept: "text/*, text/html;q=0.5", want: "text/plain"},
+		5:  {accept: "*/*;q=0.1, text/plain", want: "text/plain"},
+		6:  {accept: "text/html;q=0, */*", want: "application/json"},
+		7:  {accept: "image/*", want: ""},
+		8:  {accept: "TEXT/HTML", want: "text/html"},
+		9:  {accept: "*", want: "application/json"},
+		10: {accept: "text/html;level=1", offers: []string{"text/html", "text/html;level=1"}, want: "text/html;level=1"},
+		11: {accept: "text/html;level=1", offers: []string{"text/html;level=2"}, want: ""},
+		12: {accept: "application/json", offers: []string{}, want: ""},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		o := offers
+		if tt.offers != nil {
+			o = tt.offers
+		}
+		if got := Negotiate(req, o...); got != tt.want {
+			t.Errorf("#%d got=%q want=%q", i, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	offers := []string{"en-US", "fr", "pt-BR"}
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		0: {acceptLanguage: "", want: "en-US"},
+		1: {acceptLanguage: "fr-CH, fr;q=0.9, en;q=0.8", want: "fr"},
+		2: {acceptLanguage: "pt", want: "pt-BR"},
+		3: {acceptLanguage: "en-us", want: "en-US"},
+		4: {acceptLanguage: "en-GB", want: ""},
+		5: {acceptLanguage: "*;q=0.5, pt-BR", want: "pt-BR"},
+		6: {acceptLanguage: "*, en;q=0", want: "fr"},
+		// "e" is not a prefix of the "en" subtag.
+		7: {acceptLanguage: "e", want: ""},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if got := NegotiateLanguage(req, offers...); got != tt.want {
+			t.Errorf("#%d got=%q want=%q", i, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	offers := []string{"br", "gzip", "identity"}
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		0: {acceptEncoding: "", want: "identity"},
+		1: {acceptEncoding: "gzip, deflate, br", want: "br"},
+		2: {acceptEncoding: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		3: {acceptEncoding: "x-gzip", want: "gzip"},
+		4: {acceptEncoding: "deflate", want: "identity"},
+		5: {acceptEncoding: "*", want: "br"},
+		6: {acceptEncoding: "*;q=0", want: ""},
+		7: {acceptEncoding: "identity;q=0, gzip;q=0.1", want: "gzip"},
+		8: {acceptEncoding: "br;q=0, *", want: "gzip"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		if got := NegotiateEncoding(req, offers...); got != tt.want {
+			t.Errorf("#%d got=%q want=%q", i, got, tt.want)
+		}
+	}
+}
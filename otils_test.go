@@ -3,9 +3,12 @@ package otils_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -190,6 +193,78 @@ func TestCodedError(t *testing.T) {
 	}
 }
 
+func TestWriteCodedError(t *testing.T) {
+	tests := [...]struct {
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		0: {otils.MakeCodedError("failed to find it", 404), 404, `{"code":404,"error":"failed to find it"}`},
+		1: {fmt.Errorf("wrapped: %w", otils.MakeCodedError("slow down", 429)), 429, `{"code":429,"error":"slow down"}`},
+
+		// Other errors must not leak their details.
+		2: {errors.New("db password is hunter2"), 500, `{"code":500,"error":"Internal Server Error"}`},
+	}
+
+	for i, tt := range tests {
+		rec := httptest.NewRecorder()
+		otils.WriteCodedError(rec, httptest.NewRequest("GET", "/", nil), tt.err)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d gotCode=%d wantCode=%d", i, rec.Code, tt.wantCode)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+			t.Errorf("#%d gotBody=%s wantBody=%s", i, got, tt.wantBody)
+		}
+	}
+}
+
+func TestWriteCodedErrorNegotiation(t *testing.T) {
+	err := otils.MakeCodedError("no <such> order", 404)
+	tests := [...]struct {
+		accept   string
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		0: {"", 404, "application/json; charset=utf-8", `{"code":404,"error":"no \u003csuch\u003e order"}`},
+		1: {"*/*", 404, "application/json; charset=utf-8", `{"code":404,"error":"no \u003csuch\u003e order"}`},
+		2: {"application/json, text/plain, */*", 404, "application/json; charset=utf-8", `{"code":404,"error":"no \u003csuch\u003e order"}`},
+		3: {
+			"application/problem+json", 404, "application/problem+json",
+			`{"type":"about:blank","title":"Not Found","status":404,"detail":"no \u003csuch\u003e order"}`,
+		},
+		4: {
+			"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", 404, "text/html; charset=utf-8",
+			"<!doctype html>\n<title>404 Not Found</title>\n<h1>404 Not Found</h1>\n<p>no &lt;such&gt; order</p>",
+		},
+		5: {"text/plain", 404, "text/plain; charset=utf-8", "no <such> order"},
+		6: {"text/*;q=0.5, application/json;q=0", 404, "text/html; charset=utf-8", ""},
+		7: {"image/png", 406, "application/json; charset=utf-8", `{"code":406,"error":"not acceptable: no \u003csuch\u003e order"}`},
+		8: {"application/xml", 406, "application/json; charset=utf-8", `{"code":406,"error":"not acceptable: no \u003csuch\u003e order"}`},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		otils.WriteCodedError(rec, req, err)
+		if rec.Code != tt.wantCode {
+			t.Errorf("#%d gotCode=%d wantCode=%d", i, rec.Code, tt.wantCode)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+			t.Errorf("#%d gotType=%q wantType=%q", i, got, tt.wantType)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("#%d gotVary=%q wantVary=%q", i, got, "Accept")
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.wantBody != "" && got != tt.wantBody {
+			t.Errorf("#%d gotBody=%q wantBody=%q", i, got, tt.wantBody)
+		}
+	}
+}
+
 func TestNumericBool(t *testing.T) {
 	tests := [...]struct {
 		str     string
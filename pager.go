@@ -0,0 +1,355 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Pagination is the scheme that an API uses to split results into pages.
+type Pagination int
+
+const (
+	// PageNumberPagination requests pages by number, e.g. ?page=2.
+	PageNumberPagination Pagination = iota
+	// OffsetPagination requests pages by item offset, e.g. ?offset=40.
+	OffsetPagination
+	// CursorPagination requests pages by an opaque cursor
+	// returned with the previous page, e.g. ?cursor=dGhpcw.
+	CursorPagination
+	// LinkHeaderPagination follows the rel="next" URL of the
+	// Link header as described by RFC 8288, e.g. GitHub's API.
+	// Relative URLs are resolved against that of the page.
+	LinkHeaderPagination
+)
+
+// ErrStopPaging can be returned by the callback passed to Pager.Each
+// to stop paging early, in which case Each returns nil.
+var ErrStopPaging = errors.New("otils: stop paging")
+
+// Pager walks through the pages of a paginated JSON API and streams
+// the items of each page, in order, to a callback.
+// Sample usage is:
+//
+//	pager := &otils.Pager[Repo]{
+//		Client:    client,
+//		Path:      "/repos",
+//		Query:     &Query{Nested: true},
+//		SizeParam: "per_page",
+//		PageSize:  50,
+//	}
+//	err := pager.Each(ctx, func(repo Repo) error {
+//		fmt.Println(repo.Name)
+//		return nil
+//	})
+//
+// The query for every page is Query as transformed by ToURLValues
+// with the page number, offset or cursor set on top of it.
+type Pager[T any] struct {
+	// Client sends the page requests, if nil a zero Client is used.
+	Client *Client
+
+	// Path is the path of the first page, resolved by Client.
+	Path string
+
+	// Query if set holds the filters sent with every page.
+	Query interface{}
+
+	// Pagination is the scheme the API uses, it
+	// defaults to PageNumberPagination.
+	Pagination Pagination
+
+	// PageParam is the query parameter carrying the page number, offset
+	// or cursor. It defaults to "page", "offset" and "cursor" respectively.
+	PageParam string
+
+	// SizeParam if set is the query parameter
+	// carrying PageSize, e.g. "per_page" or "limit".
+	SizeParam string
+
+	// PageSize is the number of items requested per page. With page number
+	// and offset pagination, a page with fewer items is the last page.
+	PageSize int
+
+	// FirstPage is the number of the first page
+	// with PageNumberPagination, it defaults to 1.
+	FirstPage int64
+
+	// MaxPages if positive bounds the number of pages fetched.
+	MaxPages int
+
+	// Concurrency is the number of pages fetched at once with page number
+	// and offset pagination. Items are still delivered in order. Offset
+	// pagination only fetches concurrently if PageSize is set.
+	Concurrency int
+
+	// Decode extracts the items of a page and, with cursor pagination,
+	// the cursor of the next page, an empty cursor meaning the last page.
+	// If nil, the body is decoded as a JSON array of items.
+	Decode func(header http.Header, body []byte) (items []T, nextCursor string, err error)
+}
+
+type pageResult[T any] struct {
+	items []T
+	next  string
+	err   error
+}
+
+// Each fetches pages until the last one is reached, invoking fn with
+// every item. If fn returns ErrStopPaging, no more pages are fetched
+// and Each returns nil; any other error from fn is returned as is.
+func (p *Pager[T]) Each(ctx context.Context, fn func(item T) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var err error
+	switch p.Pagination {
+	case CursorPagination, LinkHeaderPagination:
+		err = p.eachSequential(ctx, fn)
+	default:
+		err = p.eachIndexed(ctx, fn)
+	}
+	if errors.Is(err, ErrStopPaging) {
+		return nil
+	}
+	return err
+}
+
+// All fetches every page and returns all the items.
+func (p *Pager[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	err := p.Each(ctx, func(item T) error {
+		all = append(all, item)
+		return nil
+	})
+	return all, err
+}
+
+// eachIndexed drives page number and offset pagination, whose
+// page positions are known upfront and can be fetched concurrently.
+func (p *Pager[T]) eachIndexed(ctx context.Context, fn func(T) error) error {
+	values, err := p.baseValues()
+	if err != nil {
+		return err
+	}
+
+	concurrency := p.Concurrency
+	if concurrency < 1 || (p.Pagination == OffsetPagination && p.PageSize <= 0) {
+		concurrency = 1
+	}
+	position := p.FirstPage
+	if p.Pagination == OffsetPagination {
+		position = 0
+	} else if position == 0 {
+		position = 1
+	}
+
+	fetched := 0
+	for {
+		n := concurrency
+		if p.MaxPages > 0 && fetched+n > p.MaxPages {
+			n = p.MaxPages - fetched
+		}
+		if n <= 0 {
+			return nil
+		}
+
+		positions := make([]int64, n)
+		for i := range positions {
+			positions[i] = position + int64(i)*p.step()
+		}
+		results := make([]pageResult[T], n)
+		var wg sync.WaitGroup
+		for i := range positions {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				pageValues := cloneValues(values)
+				pageValues.Set(p.pageParam(), strconv.FormatInt(positions[i], 10))
+				results[i] = p.fetch(ctx, p.Path, pageValues)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, res := range results {
+			if res.err != nil {
+				return res.err
+			}
+			fetched++
+			for _, item := range res.items {
+				if err := fn(item); err != nil {
+					return err
+				}
+			}
+			if len(res.items) == 0 || (p.PageSize > 0 && len(res.items) < p.PageSize) {
+				return nil
+			}
+			if p.Pagination == OffsetPagination && p.PageSize <= 0 {
+				position += int64(len(res.items))
+			} else {
+				position += p.step()
+			}
+		}
+	}
+}
+
+// eachSequential drives cursor and Link header pagination, where
+// each page tells where the next one is.
+func (p *Pager[T]) eachSequential(ctx context.Context, fn func(T) error) error {
+	values, err := p.baseValues()
+	if err != nil {
+		return err
+	}
+
+	path := p.Path
+	var query interface{} = values
+	for fetched := 0; p.MaxPages <= 0 || fetched < p.MaxPages; fetched++ {
+		res := p.fetch(ctx, path, query)
+		if res.err != nil {
+			return res.err
+		}
+		for _, item := range res.items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if res.next == "" {
+			return nil
+		}
+
+		if p.Pagination == LinkHeaderPagination {
+			// The next link already carries the full query.
+			path, query = res.next, nil
+		} else {
+			pageValues := cloneValues(values)
+			pageValues.Set(p.pageParam(), res.next)
+			query = pageValues
+		}
+	}
+	return nil
+}
+
+func (p *Pager[T]) fetch(ctx context.Context, path string, query interface{}) (res pageResult[T]) {
+	client := p.Client
+	if client == nil {
+		client = new(Client)
+	}
+	httpRes, blob, err := client.do(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	header := httpRes.Header
+
+	if p.Decode != nil {
+		res.items, res.next, res.err = p.Decode(header, blob)
+	} else {
+		res.err = json.Unmarshal(blob, &res.items)
+	}
+	if p.Pagination == LinkHeaderPagination && res.err == nil {
+		res.next, res.err = resolveLink(httpRes, parseLinkHeader(header.Values("Link"))["next"])
+	}
+	return res
+}
+
+// resolveLink resolves target, which may be a relative reference,
+// against the URL of the response whose Link header carried it.
+func resolveLink(res *http.Response, target string) (string, error) {
+	if target == "" || res.Request == nil {
+		return target, nil
+	}
+	ref, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	return res.Request.URL.ResolveReference(ref).String(), nil
+}
+
+func (p *Pager[T]) baseValues() (url.Values, error) {
+	values := make(url.Values)
+	if p.Query != nil {
+		qv, err := ToURLValues(p.Query)
+		if err != nil {
+			return nil, err
+		}
+		for key, vals := range qv {
+			values[key] = append(values[key], vals...)
+		}
+	}
+	if p.SizeParam != "" && p.PageSize > 0 {
+		values.Set(p.SizeParam, strconv.Itoa(p.PageSize))
+	}
+	return values, nil
+}
+
+func (p *Pager[T]) pageParam() string {
+	if p.PageParam != "" {
+		return p.PageParam
+	}
+	switch p.Pagination {
+	case OffsetPagination:
+		return "offset"
+	case CursorPagination:
+		return "cursor"
+	default:
+		return "page"
+	}
+}
+
+// step is the distance between the positions of consecutive pages.
+func (p *Pager[T]) step() int64 {
+	if p.Pagination == OffsetPagination {
+		return int64(p.PageSize)
+	}
+	return 1
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for key, vals := range values {
+		clone[key] = append([]string(nil), vals...)
+	}
+	return clone
+}
+
+// parseLinkHeader parses Link header values such as
+//
+//	<https://api.github.com/repos?page=3>; rel="next", <https://api.github.com/repos?page=9>; rel="last"
+//
+// into a map of relation types to their URLs.
+func parseLinkHeader(values []string) map[string]string {
+	links := make(map[string]string)
+	for _, value := range values {
+		for value != "" {
+			start := strings.IndexByte(value, '<')
+			end := strings.IndexByte(value, '>')
+			if start < 0 || end < start {
+				break
+			}
+			target := value[start+1 : end]
+			value = value[end+1:]
+
+			params := value
+			if next := strings.IndexByte(value, '<'); next >= 0 {
+				params, value = value[:next], value[next:]
+			} else {
+				value = ""
+			}
+			for _, param := range strings.Split(params, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(key, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimRight(val, ", "), `"`)) {
+					links[strings.ToLower(rel)] = target
+				}
+			}
+		}
+	}
+	return links
+}
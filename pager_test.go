@@ -0,0 +1,212 @@
+package otils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// paginatedServer serves the items 1 through total, PageSize at a
+// time, using page numbers, offsets, cursors and Link headers.
+func paginatedServer(total, pageSize int) *httptest.Server {
+	var tst *httptest.Server
+	tst = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("nested") != "true" {
+			http.Error(rw, "missing filter", http.StatusBadRequest)
+			return
+		}
+
+		start := 0
+		switch req.URL.Path {
+		case "/pages", "/links":
+			page, _ := strconv.Atoi(query.Get("page"))
+			if page == 0 {
+				page = 1
+			}
+			start = (page - 1) * pageSize
+			if req.URL.Path == "/links" && start+pageSize < total {
+				next := fmt.Sprintf("%s/links?nested=true&page=%d", tst.URL, page+1)
+				rw.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next", <%s/links?page=1>; rel="first"`, next, tst.URL))
+			}
+		case "/offsets":
+			start, _ = strconv.Atoi(query.Get("offset"))
+		case "/cursors":
+			start, _ = strconv.Atoi(query.Get("cursor"))
+		}
+
+		var items []int
+		for i := start; i < total && i < start+pageSize; i++ {
+			items = append(items, i+1)
+		}
+		if req.URL.Path == "/cursors" {
+			next := ""
+			if start+pageSize < total {
+				next = strconv.Itoa(start + pageSize)
+			}
+			_ = json.NewEncoder(rw).Encode(map[string]interface{}{"items": items, "next": next})
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(items)
+	}))
+	return tst
+}
+
+func TestPager(t *testing.T) {
+	tst := paginatedServer(7, 3)
+	defer tst.Close()
+
+	decodeCursor := func(header http.Header, blob []byte) ([]int, string, error) {
+		var page struct {
+			Items []int  `json:"items"`
+			Next  string `json:"next"`
+		}
+		err := json.Unmarshal(blob, &page)
+		return page.Items, page.Next, err
+	}
+
+	all := []int{1, 2, 3, 4, 5, 6, 7}
+	tests := []struct {
+		name  string
+		pager *Pager[int]
+		want  []int
+	}{
+		{
+			name:  "page numbers",
+			pager: &Pager[int]{Path: "/pages", PageSize: 3},
+			want:  all,
+		},
+		{
+			name:  "page numbers concurrently",
+			pager: &Pager[int]{Path: "/pages", PageSize: 3, Concurrency: 4},
+			want:  all,
+		},
+		{
+			name:  "max pages",
+			pager: &Pager[int]{Path: "/pages", PageSize: 3, MaxPages: 2, Concurrency: 3},
+			want:  all[:6],
+		},
+		{
+			name:  "offsets",
+			pager: &Pager[int]{Path: "/offsets", Pagination: OffsetPagination, PageSize: 3, Concurrency: 2},
+			want:  all,
+		},
+		{
+			name:  "offsets without a page size",
+			pager: &Pager[int]{Path: "/offsets", Pagination: OffsetPagination},
+			want:  all,
+		},
+		{
+			name:  "cursors",
+			pager: &Pager[int]{Path: "/cursors", Pagination: CursorPagination, Decode: decodeCursor},
+			want:  all,
+		},
+		{
+			name:  "link headers",
+			pager: &Pager[int]{Path: "/links", Pagination: LinkHeaderPagination},
+			want:  all,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.pager.Client = &Client{BaseURL: tst.URL}
+			tt.pager.Query = &struct {
+				Nested bool `json:"nested"`
+			}{Nested: true}
+
+			got, err := tt.pager.All(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got=%v want=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPagerStop(t *testing.T) {
+	tst := paginatedServer(7, 3)
+	defer tst.Close()
+
+	pager := &Pager[int]{
+		Client:   &Client{BaseURL: tst.URL},
+		Path:     "/pages",
+		Query:    map[string]bool{"nested": true},
+		PageSize: 3,
+	}
+	var got []int
+	err := pager.Each(context.Background(), func(item int) error {
+		if item == 5 {
+			return ErrStopPaging
+		}
+		got = append(got, item)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+}
+
+func TestPagerRelativeLinks(t *testing.T) {
+	var paths []string
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.URL.RequestURI())
+		if req.URL.Path != "/v1/items" {
+			http.NotFound(rw, req)
+			return
+		}
+		switch req.URL.Query().Get("page") {
+		case "":
+			rw.Header().Set("Link", `</v1/items?page=2>; rel="next"`)
+			_, _ = rw.Write([]byte("[1,2]"))
+		case "2":
+			rw.Header().Set("Link", `<items?page=3>; rel="next"`)
+			_, _ = rw.Write([]byte("[3,4]"))
+		default:
+			_, _ = rw.Write([]byte("[5]"))
+		}
+	}))
+	defer tst.Close()
+
+	pager := &Pager[int]{
+		Client:     &Client{BaseURL: tst.URL + "/v1"},
+		Path:       "/items",
+		Pagination: LinkHeaderPagination,
+	}
+	got, err := pager.All(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+	if want := []string{"/v1/items", "/v1/items?page=2", "/v1/items?page=3"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("gotPaths=%q wantPaths=%q", paths, want)
+	}
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	got := parseLinkHeader([]string{
+		`<https://api.github.com/repos?page=3&per_page=100>; rel="next", <https://api.github.com/repos?page=50>; rel="last"`,
+		`<https://api.github.com/repos?page=1>; rel="first prev"`,
+	})
+	want := map[string]string{
+		"next":  "https://api.github.com/repos?page=3&per_page=100",
+		"last":  "https://api.github.com/repos?page=50",
+		"first": "https://api.github.com/repos?page=1",
+		"prev":  "https://api.github.com/repos?page=1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+}
@@ -0,0 +1,373 @@
+package otils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend is an upstream server that ReverseProxy forwards requests to.
+type Backend struct {
+	// URL is the base URL of the backend, e.g. "http://10.0.0.2:8080/api".
+	URL string
+
+	// Weight is the share of requests the backend gets relative
+	// to the other backends, it defaults to 1.
+	Weight int
+}
+
+// ReverseProxy forwards requests to one or more backends, balancing them
+// by weight and taking backends that keep failing out of rotation for a
+// while. It takes care of the usual header hygiene:
+//
+//   - X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto and Forwarded
+//     are set for the backend, with the values sent by the client dropped
+//     unless it is a Trusted proxy.
+//   - Location headers and cookie domains that refer to the backend are
+//     rewritten to refer to the proxy.
+//   - Transport failures are answered with a 502 *CodedError, or a 504 one
+//     if the backend timed out.
+//   - When used behind CORSMiddleware, the backend's own CORS headers are
+//     dropped in favor of the middleware's and preflight requests are
+//     answered without reaching the backend.
+type ReverseProxy struct {
+	Backends []*Backend
+
+	// Transport is used to reach the backends,
+	// it defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Trusted matches requests coming from proxies whose
+	// forwarding headers are kept and appended to.
+	Trusted RequestMatcher
+
+	// PreserveHost forwards the client's Host header instead of the backend's.
+	PreserveHost bool
+
+	// CookieDomain if set replaces the Domain of cookies set by backends,
+	// otherwise the Domain attribute is removed so that cookies apply to
+	// the host the client requested.
+	CookieDomain string
+
+	// FailureThreshold is the number of consecutive failures after which
+	// a backend is taken out of rotation, it defaults to 3.
+	FailureThreshold int
+
+	// FailureCooldown is how long a failing backend is out of rotation
+	// for before it is tried again, it defaults to 30s.
+	FailureCooldown time.Duration
+
+	// ErrorLog if set logs the failures to reach backends and to copy
+	// their responses, otherwise they are not logged.
+	ErrorLog *log.Logger
+
+	backends []*proxyBackend
+	proxy    *httputil.ReverseProxy
+
+	mu sync.Mutex
+}
+
+type proxyBackend struct {
+	url    *url.URL
+	weight int
+
+	// Guarded by ReverseProxy.mu.
+	current   int
+	failures  int
+	downUntil time.Time
+}
+
+type proxyState struct {
+	backend *proxyBackend
+	host    string
+	proto   string
+	cors    bool
+}
+
+type proxyStateKey struct{}
+
+// NewReverseProxy returns a handler that forwards requests to the
+// backends of rp, which must have at least one valid URL.
+// Sample usage is:
+//
+//	proxy, err := otils.NewReverseProxy(&otils.ReverseProxy{
+//		Backends: []*otils.Backend{
+//			{URL: "http://10.0.0.2:8080", Weight: 3},
+//			{URL: "http://10.0.0.3:8080"},
+//		},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.Handle("/", otils.CORSMiddlewareAllInclusive(proxy))
+func NewReverseProxy(rp *ReverseProxy) (http.Handler, error) {
+	if rp == nil || len(rp.Backends) == 0 {
+		return nil, errors.New("otils: reverse proxy needs at least one backend")
+	}
+	copy := &ReverseProxy{
+		Transport:        rp.Transport,
+		Trusted:          rp.Trusted,
+		PreserveHost:     rp.PreserveHost,
+		CookieDomain:     rp.CookieDomain,
+		FailureThreshold: rp.FailureThreshold,
+		FailureCooldown:  rp.FailureCooldown,
+		ErrorLog:         rp.ErrorLog,
+	}
+	for i, backend := range rp.Backends {
+		u, err := url.Parse(backend.URL)
+		if err != nil {
+			return nil, fmt.Errorf("otils: backend #%d: %w", i, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("otils: backend #%d: %q is not an absolute http(s) URL", i, backend.URL)
+		}
+		weight := backend.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		copy.Backends = append(copy.Backends, &Backend{URL: backend.URL, Weight: weight})
+		copy.backends = append(copy.backends, &proxyBackend{url: u, weight: weight})
+	}
+	if copy.FailureThreshold <= 0 {
+		copy.FailureThreshold = 3
+	}
+	if copy.FailureCooldown <= 0 {
+		copy.FailureCooldown = 30 * time.Second
+	}
+	copy.proxy = &httputil.ReverseProxy{
+		Rewrite:        copy.rewrite,
+		Transport:      copy.Transport,
+		ModifyResponse: copy.modifyResponse,
+		ErrorHandler:   copy.handleError,
+		ErrorLog:       copy.ErrorLog,
+	}
+	if copy.ErrorLog == nil {
+		// httputil.ReverseProxy would use the standard logger.
+		copy.proxy.ErrorLog = log.New(io.Discard, "", 0)
+	}
+	return copy, nil
+}
+
+var _ http.Handler = (*ReverseProxy)(nil)
+
+func (rp *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// A CORSMiddleware in front of the proxy has already set the CORS
+	// headers, so it alone answers preflight requests.
+	cors := rw.Header().Get("Access-Control-Allow-Origin") != ""
+	if cors && isPreflight(req) {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if rp.proxy == nil {
+		WriteCodedError(rw, req, MakeCodedError("no backends configured", http.StatusBadGateway))
+		return
+	}
+
+	state := &proxyState{backend: rp.pick(time.Now()), host: req.Host, proto: "http", cors: cors}
+	if req.TLS != nil {
+		state.proto = "https"
+	}
+	rp.proxy.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), proxyStateKey{}, state)))
+}
+
+// pick selects a backend using smooth weighted round robin, skipping
+// the backends that are out of rotation unless all of them are.
+func (rp *ReverseProxy) pick(now time.Time) *proxyBackend {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	var candidates []*proxyBackend
+	for _, b := range rp.backends {
+		if !now.Before(b.downUntil) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = rp.backends
+	}
+
+	var best *proxyBackend
+	total := 0
+	for _, b := range candidates {
+		b.current += b.weight
+		total += b.weight
+		if best == nil || b.current > best.current {
+			best = b
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// record updates the passive health of a backend after a request.
+func (rp *ReverseProxy) record(b *proxyBackend, failed bool) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= rp.FailureThreshold {
+		b.downUntil = time.Now().Add(rp.FailureCooldown)
+		b.failures = 0
+	}
+}
+
+func (rp *ReverseProxy) rewrite(pr *httputil.ProxyRequest) {
+	state := pr.In.Context().Value(proxyStateKey{}).(*proxyState)
+	pr.SetURL(state.backend.url)
+	if rp.PreserveHost {
+		pr.Out.Host = pr.In.Host
+	}
+
+	// SetXForwarded appends to the X-Forwarded-For that Rewrite
+	// removed from Out, so restore it for trusted proxies.
+	pr.Out.Header.Del("Forwarded")
+	trusted := rp.Trusted != nil && rp.Trusted(pr.In)
+	if trusted {
+		pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+		if forwarded := pr.In.Header.Values("Forwarded"); len(forwarded) > 0 {
+			pr.Out.Header.Set("Forwarded", strings.Join(forwarded, ", "))
+		}
+	}
+	pr.SetXForwarded()
+	if trusted {
+		// Keep what the client saw as the host and scheme.
+		if host := pr.In.Header.Get("X-Forwarded-Host"); host != "" {
+			pr.Out.Header.Set("X-Forwarded-Host", host)
+		}
+		if proto := pr.In.Header.Get("X-Forwarded-Proto"); proto != "" {
+			pr.Out.Header.Set("X-Forwarded-Proto", proto)
+		}
+	}
+
+	element := "for=" + forwardedNode(pr.In.RemoteAddr) + ";host=" + quoteForwarded(pr.In.Host) + ";proto=" + state.proto
+	if prev := pr.Out.Header.Get("Forwarded"); prev != "" {
+		element = prev + ", " + element
+	}
+	pr.Out.Header.Set("Forwarded", element)
+}
+
+// forwardedNode formats a remote address as a node of the
+// Forwarded header of RFC 7239, quoting IPv6 addresses.
+func forwardedNode(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if strings.Contains(host, ":") {
+		return `"[` + host + `]"`
+	}
+	if host == "" {
+		return "unknown"
+	}
+	return host
+}
+
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":[]") {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+func (rp *ReverseProxy) modifyResponse(res *http.Response) error {
+	state := res.Request.Context().Value(proxyStateKey{}).(*proxyState)
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		rp.record(state.backend, true)
+	default:
+		rp.record(state.backend, false)
+	}
+
+	if location := res.Header.Get("Location"); location != "" {
+		res.Header.Set("Location", rp.rewriteLocation(location, state))
+	}
+	if cookies := res.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		rewritten := make([]string, len(cookies))
+		for i, cookie := range cookies {
+			rewritten[i] = rp.rewriteCookieDomain(cookie)
+		}
+		res.Header["Set-Cookie"] = rewritten
+	}
+	if state.cors {
+		for key := range res.Header {
+			if strings.HasPrefix(key, "Access-Control-") {
+				delete(res.Header, key)
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteLocation makes redirects to the backend point to the proxy.
+func (rp *ReverseProxy) rewriteLocation(location string, state *proxyState) string {
+	u, err := url.Parse(location)
+	if err != nil || !u.IsAbs() {
+		return location
+	}
+	backend := state.backend.url
+	if !strings.EqualFold(u.Host, backend.Host) {
+		return location
+	}
+	u.Scheme = state.proto
+	u.Host = state.host
+	if prefix := strings.TrimSuffix(backend.Path, "/"); prefix != "" && (u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/")) {
+		// SetURL joined the request path onto the backend's
+		// path so strip it back off.
+		u.Path = strings.TrimPrefix(u.Path, prefix)
+		u.RawPath = ""
+		if u.Path == "" {
+			u.Path = "/"
+		}
+	}
+	return u.String()
+}
+
+// rewriteCookieDomain replaces or removes the Domain attribute of a Set-Cookie value.
+func (rp *ReverseProxy) rewriteCookieDomain(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	kept := parts[:1]
+	for _, part := range parts[1:] {
+		key, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if !strings.EqualFold(key, "Domain") {
+			kept = append(kept, part)
+			continue
+		}
+		if rp.CookieDomain != "" {
+			kept = append(kept, " Domain="+rp.CookieDomain)
+		}
+	}
+	return strings.Join(kept, ";")
+}
+
+func (rp *ReverseProxy) handleError(rw http.ResponseWriter, req *http.Request, err error) {
+	state := req.Context().Value(proxyStateKey{}).(*proxyState)
+	if errors.Is(err, context.Canceled) && req.Context().Err() != nil {
+		// The client went away, there is nobody to respond to
+		// and the backend is not at fault.
+		return
+	}
+	rp.record(state.backend, true)
+	if rp.ErrorLog != nil {
+		rp.ErrorLog.Printf("otils: proxying %s %s to %s: %v", req.Method, req.URL.Path, state.backend.url.Host, err)
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		WriteCodedError(rw, req, MakeCodedError("upstream timed out", http.StatusGatewayTimeout))
+		return
+	}
+	WriteCodedError(rw, req, MakeCodedError("bad gateway", http.StatusBadGateway))
+}
@@ -0,0 +1,284 @@
+package otils
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseProxyForwardingHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req.Header.Clone()
+	}))
+	defer backend.Close()
+
+	trustLB := func(req *http.Request) bool { return req.RemoteAddr == "10.0.0.1:5000" }
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       http.Header
+	}{
+		{
+			name:       "spoofed headers from a client are dropped",
+			remoteAddr: "192.0.2.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.9"},
+				"X-Forwarded-Host":  {"evil.example"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=203.0.113.9"},
+			},
+			want: http.Header{
+				"X-Forwarded-For":   {"192.0.2.1"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Proto": {"http"},
+				"Forwarded":         {"for=192.0.2.1;host=example.com;proto=http"},
+			},
+		},
+		{
+			name:       "trusted proxy headers are appended to",
+			remoteAddr: "10.0.0.1:5000",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.9"},
+				"X-Forwarded-Host":  {"orijtech.com"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=203.0.113.9;proto=https"},
+			},
+			want: http.Header{
+				"X-Forwarded-For":   {"203.0.113.9, 10.0.0.1"},
+				"X-Forwarded-Host":  {"orijtech.com"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=203.0.113.9;proto=https, for=10.0.0.1;host=example.com;proto=http"},
+			},
+		},
+		{
+			name:       "IPv6 client",
+			remoteAddr: "[2001:db8::1]:1234",
+			want: http.Header{
+				"X-Forwarded-For":   {"2001:db8::1"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Proto": {"http"},
+				"Forwarded":         {`for="[2001:db8::1]";host=example.com;proto=http`},
+			},
+		},
+	}
+
+	proxy, err := NewReverseProxy(&ReverseProxy{Backends: []*Backend{{URL: backend.URL}}, Trusted: trustLB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("gotCode=%d wantCode=%d", rec.Code, http.StatusOK)
+			}
+			for key, want := range tt.want {
+				if got.Get(key) != want[0] || len(got.Values(key)) != 1 {
+					t.Errorf("%s: got=%q want=%q", key, got.Values(key), want)
+				}
+			}
+		})
+	}
+}
+
+func TestReverseProxyRewritesLocationAndCookies(t *testing.T) {
+	var backend *httptest.Server
+	backend = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.SetCookie(rw, &http.Cookie{Name: "session", Value: "abc", Domain: "internal.local", Path: "/", HttpOnly: true})
+		rw.Header().Set("Location", backend.URL+req.Header.Get("X-Location"))
+		rw.WriteHeader(http.StatusFound)
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		location     string
+		cookieDomain string
+		wantLocation string
+		wantCookie   string
+	}{
+		0: {location: "/api/login?next=%2F", wantLocation: "https://orijtech.com/login?next=%2F", wantCookie: "session=abc; Path=/; HttpOnly"},
+		1: {
+			location: "/api/login?next=%2F", cookieDomain: "orijtech.com",
+			wantLocation: "https://orijtech.com/login?next=%2F", wantCookie: "session=abc; Path=/; Domain=orijtech.com; HttpOnly",
+		},
+		2: {location: "/api", wantLocation: "https://orijtech.com/", wantCookie: "session=abc; Path=/; HttpOnly"},
+		// Only whole path segments are the backend's prefix.
+		3: {location: "/apiary", wantLocation: "https://orijtech.com/apiary", wantCookie: "session=abc; Path=/; HttpOnly"},
+	}
+
+	for i, tt := range tests {
+		proxy, err := NewReverseProxy(&ReverseProxy{Backends: []*Backend{{URL: backend.URL + "/api"}}, CookieDomain: tt.cookieDomain})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://orijtech.com/", nil)
+		req.Header.Set("X-Location", tt.location)
+		proxy.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Location"); got != tt.wantLocation {
+			t.Errorf("#%d Location: got=%q want=%q", i, got, tt.wantLocation)
+		}
+		if got := rec.Header().Get("Set-Cookie"); got != tt.wantCookie {
+			t.Errorf("#%d Set-Cookie: got=%q want=%q", i, got, tt.wantCookie)
+		}
+	}
+}
+
+func TestReverseProxyErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		backend   string
+		transport http.RoundTripper
+		wantCode  int
+	}{
+		{
+			name:     "unreachable backend",
+			backend:  closed.URL,
+			wantCode: http.StatusBadGateway,
+		},
+		{
+			name:      "timed out backend",
+			backend:   slow.URL,
+			transport: &http.Transport{ResponseHeaderTimeout: 20 * time.Millisecond},
+			wantCode:  http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errorLog := new(bytes.Buffer)
+			proxy, err := NewReverseProxy(&ReverseProxy{
+				Backends:  []*Backend{{URL: tt.backend}},
+				Transport: tt.transport,
+				ErrorLog:  log.New(errorLog, "", 0),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d", rec.Code, tt.wantCode)
+			}
+			if !strings.Contains(errorLog.String(), "otils: proxying GET /") {
+				t.Fatalf("expected the failure to be logged, got %q", errorLog)
+			}
+			var body codedErrorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode {
+				t.Fatalf("unexpected body %q: %v", rec.Body, err)
+			}
+		})
+	}
+}
+
+func TestReverseProxyBalancing(t *testing.T) {
+	hits := make(map[string]int)
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			hits[name]++
+		}))
+	}
+	a, b := newBackend("a"), newBackend("b")
+	defer a.Close()
+	defer b.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	proxy, err := NewReverseProxy(&ReverseProxy{
+		Backends: []*Backend{
+			{URL: a.URL, Weight: 3},
+			{URL: b.URL},
+			{URL: down.URL},
+		},
+		FailureThreshold: 2,
+		FailureCooldown:  time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes := make(map[int]int)
+	for i := 0; i < 25; i++ {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes[rec.Code]++
+	}
+	// The dead backend fails twice before it is taken out of
+	// rotation, and the rest is split 3:1 by weight.
+	if codes[http.StatusBadGateway] != 2 || codes[http.StatusOK] != 23 {
+		t.Fatalf("unexpected status codes %v", codes)
+	}
+	if hits["a"] < 3*hits["b"]-3 || hits["a"] > 3*hits["b"]+3 {
+		t.Fatalf("unexpected split a=%d b=%d", hits["a"], hits["b"])
+	}
+}
+
+func TestReverseProxyBehindCORS(t *testing.T) {
+	backendHits := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		backendHits++
+		rw.Header().Set("Access-Control-Allow-Origin", "https://legacy.example")
+	}))
+	defer backend.Close()
+
+	proxy, err := NewReverseProxy(&ReverseProxy{Backends: []*Backend{{URL: backend.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := CORSMiddleware(&CORS{Origins: []string{"https://orijtech.com"}, Methods: []string{"PUT"}}, proxy)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://orijtech.com")
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "https://orijtech.com" {
+		t.Fatalf("got=%q want only the middleware's origin", got)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://orijtech.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight gotCode=%d wantCode=%d", rec.Code, http.StatusNoContent)
+	}
+	if backendHits != 1 {
+		t.Fatalf("got=%d backend hits want=1", backendHits)
+	}
+}
+
+func TestNewReverseProxyValidation(t *testing.T) {
+	tests := []*ReverseProxy{
+		0: nil,
+		1: {},
+		2: {Backends: []*Backend{{URL: "/relative"}}},
+		3: {Backends: []*Backend{{URL: "ftp://files.example"}}},
+		4: {Backends: []*Backend{{URL: "http://%zz"}}},
+	}
+	for i, rp := range tests {
+		if _, err := NewReverseProxy(rp); err == nil {
+			t.Errorf("#%d: expected an error", i)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package otils
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm is the algorithm used to enforce a rate limit.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit requests and
+	// refills at a steady Limit requests per Window.
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests in any Window, approximated
+	// by weighting the previous fixed window's count.
+	SlidingWindow
+)
+
+// RateLimitPolicy is a limit of requests per time window.
+type RateLimitPolicy struct {
+	Limit     int
+	Window    time.Duration
+	Algorithm RateLimitAlgorithm
+}
+
+// RateLimitResult is the outcome of counting a request against a policy.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the limit is fully replenished.
+	Reset time.Duration
+	// RetryAfter is how long a rejected client should wait.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the rate limiting state of clients, allowing it to
+// live outside the process, for example in a database shared by replicas.
+type RateLimitStore interface {
+	// Take counts a request by the client identified by key against policy.
+	Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (*RateLimitResult, error)
+}
+
+// MemoryRateLimitStore is an in-process RateLimitStore. Clients are
+// tracked separately for each policy, so that RateLimiters with different
+// limits can share a store. It evicts clients that have been idle for
+// longer than twice their policy's window, and the least recently seen
+// clients once it holds more than MaxKeys.
+type MemoryRateLimitStore struct {
+	// MaxKeys if positive bounds the number of clients tracked.
+	MaxKeys int
+
+	mu      sync.Mutex
+	entries map[rateLimitKey]*list.Element
+	// recent orders the entries from the most to the least recently seen.
+	recent    *list.List
+	lastSweep time.Time
+}
+
+type rateLimitKey struct {
+	key    string
+	policy RateLimitPolicy
+}
+
+type rateLimitEntry struct {
+	key      rateLimitKey
+	lastSeen time.Time
+	idleTTL  time.Duration
+	bucket   *tokenBucket
+	window   *slidingWindow
+}
+
+var _ RateLimitStore = (*MemoryRateLimitStore)(nil)
+
+func (mrs *MemoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (*RateLimitResult, error) {
+	entry := mrs.entry(key, policy, now)
+	res := &RateLimitResult{Limit: policy.Limit}
+
+	switch policy.Algorithm {
+	case SlidingWindow:
+		res.Allowed, res.Remaining, res.Reset, res.RetryAfter = entry.window.take(now)
+	default:
+		var ok bool
+		ok, res.RetryAfter = entry.bucket.take(now)
+		res.Allowed = ok
+		res.Remaining, res.Reset = entry.bucket.status(now)
+	}
+	return res, nil
+}
+
+func (mrs *MemoryRateLimitStore) entry(key string, policy RateLimitPolicy, now time.Time) *rateLimitEntry {
+	mrs.mu.Lock()
+	defer mrs.mu.Unlock()
+
+	if mrs.entries == nil {
+		mrs.entries = make(map[rateLimitKey]*list.Element)
+		mrs.recent = list.New()
+	}
+	if now.Sub(mrs.lastSweep) >= policy.Window {
+		mrs.sweep(now)
+	}
+
+	var entry *rateLimitEntry
+	elemKey := rateLimitKey{key: key, policy: policy}
+	elem, ok := mrs.entries[elemKey]
+	if ok {
+		entry = elem.Value.(*rateLimitEntry)
+		mrs.recent.MoveToFront(elem)
+	} else {
+		if mrs.MaxKeys > 0 && len(mrs.entries) >= mrs.MaxKeys {
+			mrs.remove(mrs.recent.Back())
+		}
+		entry = &rateLimitEntry{key: elemKey, idleTTL: 2 * policy.Window}
+		if policy.Algorithm == SlidingWindow {
+			entry.window = &slidingWindow{limit: policy.Limit, size: policy.Window}
+		} else {
+			entry.bucket = newTokenBucket(float64(policy.Limit)/policy.Window.Seconds(), policy.Limit)
+		}
+		mrs.entries[elemKey] = mrs.recent.PushFront(entry)
+	}
+	entry.lastSeen = now
+	return entry
+}
+
+// sweep evicts the least recently seen entries that have been idle for
+// too long, stopping at the first one that has not. Idle entries of
+// policies with shorter windows behind it are left for later sweeps or
+// eviction.
+func (mrs *MemoryRateLimitStore) sweep(now time.Time) {
+	for elem := mrs.recent.Back(); elem != nil; elem = mrs.recent.Back() {
+		if entry := elem.Value.(*rateLimitEntry); now.Sub(entry.lastSeen) <= entry.idleTTL {
+			break
+		}
+		mrs.remove(elem)
+	}
+	mrs.lastSweep = now
+}
+
+func (mrs *MemoryRateLimitStore) remove(elem *list.Element) {
+	entry := mrs.recent.Remove(elem).(*rateLimitEntry)
+	delete(mrs.entries, entry.key)
+}
+
+// slidingWindow approximates a sliding window by weighting
+// the count of the previous fixed window by its overlap.
+type slidingWindow struct {
+	limit int
+	size  time.Duration
+
+	mu    sync.Mutex
+	start time.Time
+	prev  int
+	cur   int
+}
+
+func (sw *slidingWindow) take(now time.Time) (ok bool, remaining int, reset, retryAfter time.Duration) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if sw.start.IsZero() {
+		sw.start = now
+	}
+	if elapsed := now.Sub(sw.start); elapsed >= sw.size {
+		windows := int(elapsed / sw.size)
+		if windows == 1 {
+			sw.prev = sw.cur
+		} else {
+			sw.prev = 0
+		}
+		sw.cur = 0
+		sw.start = sw.start.Add(time.Duration(windows) * sw.size)
+	}
+
+	elapsed := now.Sub(sw.start)
+	weight := 1 - float64(elapsed)/float64(sw.size)
+	estimate := float64(sw.prev)*weight + float64(sw.cur)
+
+	if estimate+1 > float64(sw.limit) {
+		// Wait until enough of the previous window has slid out, or
+		// failing that until the current window becomes the previous.
+		retryAfter = sw.size - elapsed
+		if sw.cur+1 <= sw.limit && sw.prev > 0 {
+			need := 1 - float64(sw.limit-sw.cur-1)/float64(sw.prev)
+			retryAfter = time.Duration(need*float64(sw.size)) - elapsed
+		}
+		return false, 0, 2*sw.size - elapsed, retryAfter
+	}
+
+	// Requests of the current window still count until the end of
+	// the next one, by which time they have fully slid out.
+	sw.cur++
+	reset = 2*sw.size - elapsed
+	remaining = sw.limit - int(math.Ceil(estimate)) - 1
+	if remaining < 0 {
+		remaining = 0
+	}
+	return true, remaining, reset, 0
+}
+
+// KeyByClientIP keys requests by the client's IP address. The forwarding
+// headers are only honoured for requests that come from the trusted
+// proxies, as described by ClientIPResolver, whose ClientIP method can
+// also be used as a key directly.
+func KeyByClientIP(trustedProxies ...*net.IPNet) func(*http.Request) string {
+	resolver := &ClientIPResolver{TrustedProxies: trustedProxies}
+	return resolver.ClientIP
+}
+
+// KeyByHeader keys requests by the value of header, e.g. "X-API-Key".
+// Requests without the header are keyed by client IP. As clients choose
+// the value, a RateLimiter using it must only see requests whose key has
+// been authenticated, otherwise clients evade their limit by sending a
+// new value with every request.
+func KeyByHeader(header string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		if value := req.Header.Get(header); value != "" {
+			return header + ":" + value
+		}
+		return remoteHost(req)
+	}
+}
+
+// RateLimiter is a middleware that limits how many requests each client
+// can make, responding with a 429 *CodedError once a client is over its
+// limit. Responses carry the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers and rejected ones a Retry-After header.
+// Sample usage is:
+//
+//	handler := authenticateAPIKey(otils.RateLimitMiddleware(&otils.RateLimiter{
+//		Limit:  100,
+//		Window: time.Minute,
+//		Key:    otils.KeyByHeader("X-API-Key"),
+//	}, mux))
+//
+// If the store fails, requests are let through.
+type RateLimiter struct {
+	// Limit is the number of requests allowed per Window.
+	Limit  int
+	Window time.Duration
+
+	Algorithm RateLimitAlgorithm
+
+	// Key identifies the client making a request,
+	// it defaults to KeyByClientIP().
+	Key func(req *http.Request) string
+
+	// Store keeps the state of clients, if nil a
+	// MemoryRateLimitStore holding up to 100k clients is used.
+	Store RateLimitStore
+
+	next http.Handler
+}
+
+// RateLimitMiddleware returns a handler that rate limits requests before
+// passing them on to next. A nil RateLimiter or one without a positive
+// Limit and Window does not limit requests.
+func RateLimitMiddleware(rl *RateLimiter, next http.Handler) http.Handler {
+	if rl == nil || rl.Limit <= 0 || rl.Window <= 0 {
+		return next
+	}
+	copy := new(RateLimiter)
+	*copy = *rl
+	copy.next = next
+	if copy.Key == nil {
+		copy.Key = KeyByClientIP()
+	}
+	if copy.Store == nil {
+		copy.Store = &MemoryRateLimitStore{MaxKeys: 100000}
+	}
+	return copy
+}
+
+var _ http.Handler = (*RateLimiter)(nil)
+
+func (rl *RateLimiter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	policy := RateLimitPolicy{Limit: rl.Limit, Window: rl.Window, Algorithm: rl.Algorithm}
+	res, err := rl.Store.Take(req.Context(), rl.Key(req), policy, time.Now())
+	if err == nil {
+		header := rw.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		header.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+		if !res.Allowed {
+			header.Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+			WriteCodedError(rw, req, MakeCodedError("rate limit exceeded", http.StatusTooManyRequests))
+			return
+		}
+	}
+	if rl.next != nil {
+		rl.next.ServeHTTP(rw, req)
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
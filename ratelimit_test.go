@@ -0,0 +1,173 @@
+package otils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	for _, algorithm := range []RateLimitAlgorithm{TokenBucket, SlidingWindow} {
+		handler := RateLimitMiddleware(&RateLimiter{
+			Limit:     2,
+			Window:    time.Hour,
+			Algorithm: algorithm,
+			Key:       KeyByHeader("X-API-Key"),
+		}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+		tests := []struct {
+			key           string
+			wantCode      int
+			wantRemaining string
+		}{
+			0: {"alice", http.StatusOK, "1"},
+			1: {"alice", http.StatusOK, "0"},
+			2: {"alice", http.StatusTooManyRequests, "0"},
+			3: {"bob", http.StatusOK, "1"},
+		}
+
+		for i, tt := range tests {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-API-Key", tt.key)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("algorithm=%d #%d gotCode=%d wantCode=%d", algorithm, i, rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("algorithm=%d #%d gotRemaining=%s wantRemaining=%s", algorithm, i, got, tt.wantRemaining)
+			}
+			if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+				t.Errorf("algorithm=%d #%d gotLimit=%s wantLimit=2", algorithm, i, got)
+			}
+			retryAfter := rec.Header().Get("Retry-After")
+			if (tt.wantCode == http.StatusTooManyRequests) != (retryAfter != "") {
+				t.Errorf("algorithm=%d #%d unexpected Retry-After=%q", algorithm, i, retryAfter)
+			}
+		}
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	start := time.Date(2021, time.January, 2, 15, 4, 5, 0, time.UTC)
+	sw := &slidingWindow{limit: 4, size: time.Minute}
+
+	tests := []struct {
+		at     time.Duration
+		wantOK bool
+	}{
+		0: {0, true},
+		1: {10 * time.Second, true},
+		2: {20 * time.Second, true},
+		3: {30 * time.Second, true},
+		4: {40 * time.Second, false},
+		// A quarter into the next window, the previous
+		// window's 4 requests still weigh 3.
+		5: {75 * time.Second, true},
+		6: {76 * time.Second, false},
+		// Halfway through, they weigh 2.
+		7: {90 * time.Second, true},
+		8: {3 * time.Minute, true},
+	}
+
+	for i, tt := range tests {
+		ok, _, _, _ := sw.take(start.Add(tt.at))
+		if ok != tt.wantOK {
+			t.Errorf("#%d at %s got=%t want=%t", i, tt.at, ok, tt.wantOK)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreEviction(t *testing.T) {
+	store := &MemoryRateLimitStore{MaxKeys: 2}
+	policy := RateLimitPolicy{Limit: 1, Window: time.Minute}
+	now := time.Now()
+	ctx := context.Background()
+
+	for i, key := range []string{"a", "b", "c"} {
+		if _, err := store.Take(ctx, key, policy, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	if _, ok := store.entries[rateLimitKey{"a", policy}]; ok || len(store.entries) != 2 {
+		t.Fatalf("expected the oldest key to be evicted, got %d entries", len(store.entries))
+	}
+
+	// Seeing "b" again makes "c" the least recently seen.
+	for i, key := range []string{"b", "d"} {
+		if _, err := store.Take(ctx, key, policy, now.Add(time.Duration(3+i)*time.Second)); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	if _, ok := store.entries[rateLimitKey{"c", policy}]; ok || len(store.entries) != 2 || store.recent.Len() != 2 {
+		t.Fatalf("expected the least recently seen key to be evicted, got %d entries", len(store.entries))
+	}
+
+	// Idle entries are swept once their TTL has elapsed.
+	if _, err := store.Take(ctx, "e", policy, now.Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(store.entries) != 1 || store.recent.Len() != 1 {
+		t.Fatalf("expected idle entries to be swept, got %d entries", len(store.entries))
+	}
+}
+
+func TestMemoryRateLimitStoreSharedByPolicies(t *testing.T) {
+	store := new(MemoryRateLimitStore)
+	strict := RateLimitPolicy{Limit: 2, Window: time.Minute}
+	loose := RateLimitPolicy{Limit: 1000, Window: time.Minute}
+	now := time.Now()
+	ctx := context.Background()
+
+	// The client is seen under the looser policy first, and
+	// using it does not reset the state of the stricter one.
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		for _, policy := range []RateLimitPolicy{loose, strict} {
+			res, err := store.Take(ctx, "192.0.2.1", policy, now)
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if policy == strict && res.Allowed {
+				allowed++
+			}
+		}
+	}
+	if allowed != strict.Limit {
+		t.Fatalf("strict policy: got=%d allowed want=%d", allowed, strict.Limit)
+	}
+}
+
+func TestKeyByClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	key := KeyByClientIP(proxies)
+
+	tests := []struct {
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		0: {"203.0.113.9:1234", "198.51.100.1", "203.0.113.9"},
+		1: {"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		2: {"10.0.0.1:1234", "1.2.3.4, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		3: {"10.0.0.1:1234", "", "10.0.0.1"},
+		4: {"10.0.0.1:1234", "garbage, 10.0.0.3", "10.0.0.3"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		// Passed through untouched by most proxies, so never believed.
+		req.Header.Set("Forwarded", "for=192.0.2.99")
+		if got := key(req); got != tt.want {
+			t.Errorf("#%d got=%q want=%q", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package otils
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recoverer is a middleware that recovers from panics in the next handler
+// and responds with a 500 *CodedError rendered by WriteCodedError, instead
+// of letting net/http drop the connection. If the handler had already
+// started writing its response, nothing more is written.
+//
+// Panics with http.ErrAbortHandler are re-panicked so that net/http
+// aborts the response as that sentinel requests.
+type Recoverer struct {
+	// OnPanic if set is invoked with every recovered value and the stack
+	// trace of the panicking goroutine, for example for logging. Otherwise
+	// they are logged with the standard logger.
+	OnPanic func(req *http.Request, recovered interface{}, stack []byte)
+
+	next http.Handler
+}
+
+// RecoverMiddleware returns a handler that recovers from panics in next.
+// A nil Recoverer uses the default settings.
+func RecoverMiddleware(r *Recoverer, next http.Handler) http.Handler {
+	copy := new(Recoverer)
+	if r != nil {
+		*copy = *r
+	}
+	copy.next = next
+	return copy
+}
+
+var _ http.Handler = (*Recoverer)(nil)
+
+func (r *Recoverer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if r.next == nil {
+		return
+	}
+
+	wrapped, w := wrapResponseWriter(rw)
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+
+		stack := debug.Stack()
+		if r.OnPanic != nil {
+			r.OnPanic(req, recovered, stack)
+		} else {
+			log.Printf("otils: panic serving %s %s: %v\n%s", req.Method, req.URL, recovered, stack)
+		}
+
+		if !w.wroteHeader {
+			WriteCodedError(rw, req, MakeCodedError(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError))
+		}
+	}()
+	r.next.ServeHTTP(wrapped, req)
+}
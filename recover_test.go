@@ -0,0 +1,83 @@
+package otils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		wantCode int
+		wantBody string
+		wantLog  bool
+	}{
+		{
+			name:     "no panic",
+			handler:  func(rw http.ResponseWriter, req *http.Request) { _, _ = rw.Write([]byte("ok")) },
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "panic before writing",
+			handler:  func(rw http.ResponseWriter, req *http.Request) { panic("boom") },
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"code":500,"error":"Internal Server Error"}`,
+			wantLog:  true,
+		},
+		{
+			name: "panic after writing",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusAccepted)
+				_, _ = rw.Write([]byte("partial"))
+				panic("boom")
+			},
+			wantCode: http.StatusAccepted,
+			wantBody: "partial",
+			wantLog:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLog string
+			handler := RecoverMiddleware(&Recoverer{
+				OnPanic: func(req *http.Request, recovered interface{}, stack []byte) {
+					gotLog = recovered.(string) + "\n" + string(stack)
+				},
+			}, tt.handler)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("gotCode=%d wantCode=%d", rec.Code, tt.wantCode)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("gotBody=%q wantBody=%q", got, tt.wantBody)
+			}
+			if tt.wantLog && !strings.Contains(gotLog, "goroutine") {
+				t.Errorf("expected the stack to be captured, got %q", gotLog)
+			}
+			if !tt.wantLog && gotLog != "" {
+				t.Errorf("unexpected panic log: %q", gotLog)
+			}
+		})
+	}
+}
+
+func TestRecoverMiddlewareAbortHandler(t *testing.T) {
+	handler := RecoverMiddleware(nil, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Fatalf("expected http.ErrAbortHandler to be re-panicked, got %v", r)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,133 @@
+package otils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader is the default header carrying request IDs.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID is a middleware that tags every request with an ID, stores
+// it in the request's context for RequestIDFromContext and echoes it in
+// the response header. The ID is then included in the error responses
+// of WriteCodedError and sent along by Client on outbound requests
+// made with the request's context.
+//
+// Incoming IDs, from the request ID header or else from the trace ID of
+// a W3C traceparent header, are only reused for requests matched by
+// Trusted; all other requests get a newly generated ID.
+type RequestID struct {
+	// Header is the request and response header carrying
+	// the ID, it defaults to RequestIDHeader.
+	Header string
+
+	// Trusted if set matches requests from sources whose
+	// incoming IDs can be trusted, such as internal proxies.
+	Trusted RequestMatcher
+
+	// Generate if set generates new IDs, otherwise
+	// random 128-bit hex encoded IDs are generated.
+	Generate func() string
+
+	next http.Handler
+}
+
+// RequestIDMiddleware returns a handler that tags requests with an ID
+// before passing them to next. A nil RequestID uses the default settings.
+func RequestIDMiddleware(ri *RequestID, next http.Handler) http.Handler {
+	copy := new(RequestID)
+	if ri != nil {
+		*copy = *ri
+	}
+	copy.next = next
+	return copy
+}
+
+var _ http.Handler = (*RequestID)(nil)
+
+func (ri *RequestID) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	header := ri.header()
+	id := ""
+	if ri.Trusted != nil && ri.Trusted(req) {
+		id = incomingRequestID(req, header)
+	}
+	if id == "" {
+		id = ri.generate()
+	}
+
+	rw.Header().Set(header, id)
+	if ri.next != nil {
+		ri.next.ServeHTTP(rw, req.WithContext(ContextWithRequestID(req.Context(), id)))
+	}
+}
+
+func (ri *RequestID) header() string {
+	if ri.Header != "" {
+		return ri.Header
+	}
+	return RequestIDHeader
+}
+
+func (ri *RequestID) generate() string {
+	if ri.Generate != nil {
+		return ri.Generate()
+	}
+	return newRandomID()
+}
+
+// incomingRequestID returns the request ID carried by req in header, or
+// else the trace ID of its traceparent header, if any is well formed.
+func incomingRequestID(req *http.Request, header string) string {
+	if id := strings.TrimSpace(req.Header.Get(header)); validRequestID(id) {
+		return id
+	}
+	// traceparent is "<version>-<trace-id>-<parent-id>-<flags>", see
+	// https://www.w3.org/TR/trace-context/#traceparent-header
+	parts := strings.Split(strings.TrimSpace(req.Header.Get("traceparent")), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+	return parts[1]
+}
+
+// validRequestID reports whether id is short and made only of printable
+// ASCII characters, so that it can be safely echoed and logged.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRandomID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,119 @@
+package otils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	trustInternal := func(req *http.Request) bool { return req.Header.Get("X-Internal") == "1" }
+
+	tests := []struct {
+		name    string
+		ri      *RequestID
+		header  http.Header
+		wantID  string
+		wantNew bool
+	}{
+		{
+			name:    "generated",
+			wantNew: true,
+		},
+		{
+			name:    "untrusted incoming ID is replaced",
+			ri:      &RequestID{Trusted: trustInternal},
+			header:  http.Header{"X-Request-Id": {"spoofed"}},
+			wantNew: true,
+		},
+		{
+			name:   "trusted incoming ID",
+			ri:     &RequestID{Trusted: trustInternal},
+			header: http.Header{"X-Request-Id": {"abc-123"}, "X-Internal": {"1"}},
+			wantID: "abc-123",
+		},
+		{
+			name: "trusted traceparent",
+			ri:   &RequestID{Trusted: trustInternal},
+			header: http.Header{
+				"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+				"X-Internal":  {"1"},
+			},
+			wantID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name:    "malformed trusted ID",
+			ri:      &RequestID{Trusted: trustInternal},
+			header:  http.Header{"X-Request-Id": {"bad id\n"}, "X-Internal": {"1"}},
+			wantNew: true,
+		},
+		{
+			name:   "custom header and generator",
+			ri:     &RequestID{Header: "X-Correlation-ID", Generate: func() string { return "fixed" }},
+			wantID: "fixed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID string
+			handler := RequestIDMiddleware(tt.ri, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				ctxID = RequestIDFromContext(req.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			header := RequestIDHeader
+			if tt.ri != nil && tt.ri.Header != "" {
+				header = tt.ri.Header
+			}
+			echoed := rec.Header().Get(header)
+			if echoed != ctxID {
+				t.Fatalf("echoed ID %q differs from the context's %q", echoed, ctxID)
+			}
+			if tt.wantNew {
+				if len(ctxID) != 32 || ctxID == req.Header.Get(RequestIDHeader) {
+					t.Fatalf("expected a newly generated ID, got %q", ctxID)
+				}
+				return
+			}
+			if ctxID != tt.wantID {
+				t.Fatalf("gotID=%q wantID=%q", ctxID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		WriteCodedError(rw, req, MakeCodedError("upstream saw "+req.Header.Get(RequestIDHeader), http.StatusTeapot))
+	}))
+	defer upstream.Close()
+
+	client := &Client{BaseURL: upstream.URL}
+	handler := RequestIDMiddleware(&RequestID{Generate: func() string { return "req-1" }}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		WriteCodedError(rw, req, client.GetJSON(req.Context(), "/", nil, nil))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var got codedErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %q: %v", rec.Body, err)
+	}
+	want := codedErrorBody{Code: http.StatusTeapot, Error: "upstream saw req-1", RequestID: "req-1"}
+	if got != want {
+		t.Fatalf("got=%+v want=%+v", got, want)
+	}
+	if !strings.Contains(rec.Header().Get("Content-Type"), "json") {
+		t.Fatalf("unexpected Content-Type %q", rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,213 @@
+package otils
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// responseWriter wraps an http.ResponseWriter to record the status code,
+// the number of body bytes written and whether the response was started.
+type responseWriter struct {
+	rw          http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	hijacked    bool
+}
+
+type unwrapper interface {
+	Unwrap() http.ResponseWriter
+}
+
+const (
+	canFlush = 1 << iota
+	canHijack
+	canPush
+	canReadFrom
+)
+
+// wrapResponseWriter wraps rw into a recording responseWriter. The returned
+// http.ResponseWriter only implements http.Flusher, http.Hijacker,
+// http.Pusher and io.ReaderFrom if rw does, so that handlers probing for
+// those interfaces keep seeing the capabilities of the original writer.
+func wrapResponseWriter(rw http.ResponseWriter) (http.ResponseWriter, *responseWriter) {
+	w := &responseWriter{rw: rw, status: http.StatusOK}
+
+	var caps int
+	if _, ok := rw.(http.Flusher); ok {
+		caps |= canFlush
+	}
+	if _, ok := rw.(http.Hijacker); ok {
+		caps |= canHijack
+	}
+	if _, ok := rw.(http.Pusher); ok {
+		caps |= canPush
+	}
+	if _, ok := rw.(io.ReaderFrom); ok {
+		caps |= canReadFrom
+	}
+
+	switch caps {
+	case 0:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+		}{w, w}, w
+	case canFlush:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+		}{w, w, w}, w
+	case canHijack:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+		}{w, w, w}, w
+	case canFlush | canHijack:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Hijacker
+		}{w, w, w, w}, w
+	case canPush:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Pusher
+		}{w, w, w}, w
+	case canFlush | canPush:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Pusher
+		}{w, w, w, w}, w
+	case canHijack | canPush:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w}, w
+	case canFlush | canHijack | canPush:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w, w}, w
+	case canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			io.ReaderFrom
+		}{w, w, w}, w
+	case canFlush | canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w, w}, w
+	case canHijack | canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w}, w
+	case canFlush | canHijack | canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w, w}, w
+	case canPush | canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w}, w
+	case canFlush | canPush | canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w, w}, w
+	case canHijack | canPush | canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w, w}, w
+	case canFlush | canHijack | canPush | canReadFrom:
+		return struct {
+			http.ResponseWriter
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, w, w, w, w, w}, w
+	}
+	panic("unreachable")
+}
+
+func (w *responseWriter) Header() http.Header { return w.rw.Header() }
+
+func (w *responseWriter) WriteHeader(code int) {
+	// Informational responses are not the final status.
+	if !w.wroteHeader && (code < 100 || code > 199 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.rw.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.rw.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	w.wroteHeader = true
+	w.rw.(http.Flusher).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.rw.(http.Hijacker).Hijack()
+	if err == nil {
+		w.wroteHeader = true
+		w.hijacked = true
+	}
+	return conn, brw, err
+}
+
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.rw.(http.Pusher).Push(target, opts)
+}
+
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wroteHeader = true
+	n, err := w.rw.(io.ReaderFrom).ReadFrom(r)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter { return w.rw }
@@ -0,0 +1,216 @@
+package otils
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryTransport is an http.RoundTripper that retries idempotent requests
+// that failed with a network error or with a retryable status code such as
+// 429 Too Many Requests or a 5XX, waiting between attempts with exponential
+// backoff plus jitter or for as long as the server's Retry-After asks.
+// Sample usage is:
+//
+//	client := &http.Client{
+//		Transport: &otils.RetryTransport{MaxAttempts: 4},
+//	}
+//
+// Requests with a body are only retried if their GetBody is set, as is
+// the case for requests created by http.NewRequest with common body types.
+type RetryTransport struct {
+	// Base is the underlying transport, if nil http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// MaxAttempts is the maximum number of times a request is sent,
+	// including the first attempt. If zero, 3 attempts are made.
+	MaxAttempts int
+
+	// MaxElapsed if set bounds the total time spent across all attempts;
+	// a retry that would start past it is not made.
+	MaxElapsed time.Duration
+
+	// MinBackoff is the base wait before the first retry, it defaults to 100ms.
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the wait between attempts, it defaults to 10s.
+	// It also caps the wait requested by a Retry-After header.
+	MaxBackoff time.Duration
+
+	// ShouldRetry if set decides whether a response or error is retried,
+	// otherwise network errors, 429 and 5XX statuses except 501 are retried.
+	ShouldRetry func(res *http.Response, err error) bool
+}
+
+var _ http.RoundTripper = (*RetryTransport)(nil)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryMinBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+)
+
+func (rt *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.canRetry(req) {
+		return rt.base().RoundTrip(req)
+	}
+
+	start := time.Now()
+	maxAttempts := rt.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultRetryAttempts
+	}
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		res, err := rt.base().RoundTrip(attemptReq)
+		if !rt.shouldRetry(res, err) || attempt >= maxAttempts || ctx.Err() != nil {
+			return res, err
+		}
+
+		wait := rt.backoff(attempt)
+		if res != nil {
+			if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				wait = retryAfter
+				if max := rt.maxBackoff(); wait > max {
+					wait = max
+				}
+			}
+		}
+		if rt.MaxElapsed > 0 && time.Since(start)+wait > rt.MaxElapsed {
+			return res, err
+		}
+
+		if res != nil {
+			drainAndClose(res.Body)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (rt *RetryTransport) base() http.RoundTripper {
+	if rt.Base != nil {
+		return rt.Base
+	}
+	return http.DefaultTransport
+}
+
+// canRetry reports whether req can safely be sent more than once.
+func (rt *RetryTransport) canRetry(req *http.Request) bool {
+	if !isIdempotent(req) {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func (rt *RetryTransport) shouldRetry(res *http.Response, err error) bool {
+	if rt.ShouldRetry != nil {
+		return rt.ShouldRetry(res, err)
+	}
+	if err != nil {
+		return true
+	}
+	return RetryableStatus(res.StatusCode)
+}
+
+// backoff returns the exponential backoff with full jitter
+// to wait after the given attempt.
+func (rt *RetryTransport) backoff(attempt int) time.Duration {
+	min := rt.MinBackoff
+	if min <= 0 {
+		min = defaultRetryMinBackoff
+	}
+	max := rt.maxBackoff()
+	ceil := min
+	for i := 1; i < attempt && ceil < max; i++ {
+		ceil *= 2
+	}
+	if ceil > max {
+		ceil = max
+	}
+	// Keep at least half of the ceiling so that
+	// jitter never collapses the wait to zero.
+	half := ceil / 2
+	return half + time.Duration(rand.Int63n(int64(ceil-half)+1))
+}
+
+func (rt *RetryTransport) maxBackoff() time.Duration {
+	if rt.MaxBackoff > 0 {
+		return rt.MaxBackoff
+	}
+	return defaultRetryMaxBackoff
+}
+
+// RetryableStatus returns true if a status code is one that StatusOK
+// rejects but that is worth retrying: 429 and all 5XX codes except 501.
+func RetryableStatus(code int) bool {
+	if code == http.StatusTooManyRequests {
+		return true
+	}
+	return code >= 500 && code <= 599 && code != http.StatusNotImplemented
+}
+
+// isIdempotent reports whether req uses an idempotent method or
+// carries an Idempotency-Key header, the same rule net/http uses.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if _, ok := req.Header["Idempotency-Key"]; ok {
+		return true
+	}
+	_, ok := req.Header["X-Idempotency-Key"]
+	return ok
+}
+
+// parseRetryAfter parses the value of a Retry-After header which
+// is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	when, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := when.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// drainAndClose reads a bounded amount of the body so that the
+// underlying connection can be reused, then closes it.
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(body, 4<<10))
+	_ = body.Close()
+}
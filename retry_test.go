@@ -0,0 +1,160 @@
+package otils
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		statuses     []int
+		retryAfter   string
+		maxAttempts  int
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{
+			name:         "retries 503 until success",
+			method:       http.MethodGet,
+			statuses:     []int{503, 502, 200},
+			wantStatus:   200,
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max attempts",
+			method:       http.MethodGet,
+			statuses:     []int{500, 500, 500, 500},
+			maxAttempts:  2,
+			wantStatus:   500,
+			wantAttempts: 2,
+		},
+		{
+			name:         "does not retry 4XX",
+			method:       http.MethodGet,
+			statuses:     []int{404, 200},
+			wantStatus:   404,
+			wantAttempts: 1,
+		},
+		{
+			name:         "honours Retry-After on 429",
+			method:       http.MethodGet,
+			statuses:     []int{429, 200},
+			retryAfter:   "0",
+			wantStatus:   200,
+			wantAttempts: 2,
+		},
+		{
+			name:         "does not retry POST",
+			method:       http.MethodPost,
+			body:         "payload",
+			statuses:     []int{503, 200},
+			wantStatus:   503,
+			wantAttempts: 1,
+		},
+		{
+			name:         "rewinds PUT body",
+			method:       http.MethodPut,
+			body:         "payload",
+			statuses:     []int{503, 200},
+			wantStatus:   200,
+			wantAttempts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				if tt.body != "" {
+					blob, _ := ioutil.ReadAll(req.Body)
+					if string(blob) != tt.body {
+						t.Errorf("attempt #%d: got body=%q want=%q", n, blob, tt.body)
+					}
+				}
+				if tt.retryAfter != "" {
+					rw.Header().Set("Retry-After", tt.retryAfter)
+				}
+				rw.WriteHeader(tt.statuses[n-1])
+			}))
+			defer tst.Close()
+
+			client := &http.Client{Transport: &RetryTransport{
+				MaxAttempts: tt.maxAttempts,
+				MinBackoff:  time.Millisecond,
+				MaxBackoff:  5 * time.Millisecond,
+			}}
+			req, _ := http.NewRequest(tt.method, tst.URL, nil)
+			if tt.body != "" {
+				req, _ = http.NewRequest(tt.method, tst.URL, strings.NewReader(tt.body))
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("gotStatus=%d wantStatus=%d", res.StatusCode, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("gotAttempts=%d wantAttempts=%d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransportContextCancel(t *testing.T) {
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer tst.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	client := &http.Client{Transport: &RetryTransport{
+		MaxAttempts: 100,
+		MinBackoff:  time.Second,
+		MaxBackoff:  time.Second,
+	}}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, tst.URL, nil)
+	start := time.Now()
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("expected a non-nil error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("took %s to return after the context expired", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, time.January, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%q: got=(%s, %t) want=(%s, %t)", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package otils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ServeConfig configures how Serve shuts servers down.
+type ServeConfig struct {
+	// Signals that trigger a graceful shutdown,
+	// they default to SIGINT and SIGTERM.
+	Signals []os.Signal
+
+	// ShutdownTimeout bounds how long connections are drained and
+	// shutdown hooks run for, it defaults to 30s. Connections that are
+	// still open once it elapses are closed forcibly.
+	ShutdownTimeout time.Duration
+
+	// Health if set is marked as not ready when shutting down.
+	Health *Health
+
+	// DrainDelay is how long to wait after marking Health as not ready
+	// before draining connections, giving load balancers time to
+	// notice and stop sending new traffic.
+	DrainDelay time.Duration
+
+	// ShutdownHooks are run in order once the servers have drained,
+	// e.g. to flush telemetry or close database connections.
+	ShutdownHooks []func(ctx context.Context) error
+}
+
+// Serve runs servers until ctx is done, one of the Signals is received or
+// any of them fails, and then shuts them all down gracefully. Servers with
+// a TLSConfig that has certificates are served over TLS. The returned
+// error combines the errors of the servers, of their shutdown and of the
+// hooks; it is nil after a clean shutdown.
+// Sample usage is:
+//
+//	redirect := &http.Server{Addr: ":80", Handler: otils.RedirectAllTrafficTo("https://orijtech.com")}
+//	app := &http.Server{Addr: ":443", Handler: mux, TLSConfig: tlsConfig}
+//	cfg := &otils.ServeConfig{Health: health, DrainDelay: 5 * time.Second}
+//	if err := otils.Serve(context.Background(), cfg, redirect, app); err != nil {
+//		log.Fatal(err)
+//	}
+func Serve(ctx context.Context, cfg *ServeConfig, servers ...*http.Server) error {
+	if cfg == nil {
+		cfg = new(ServeConfig)
+	}
+	signals := cfg.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, signals...)
+	defer stop()
+
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			var err error
+			if srv.TLSConfig != nil && (len(srv.TLSConfig.Certificates) > 0 || srv.TLSConfig.GetCertificate != nil) {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			} else if err != nil {
+				err = fmt.Errorf("serving %q: %w", srv.Addr, err)
+			}
+			errc <- err
+		}(srv)
+	}
+
+	var errs []error
+	pending := len(servers)
+	select {
+	case <-ctx.Done():
+	case err := <-errc:
+		pending--
+		errs = append(errs, err)
+	}
+	// Restore the default behavior so that a second
+	// signal kills a process that is stuck shutting down.
+	stop()
+
+	if cfg.Health != nil {
+		cfg.Health.SetReady(false)
+		if cfg.DrainDelay > 0 {
+			time.Sleep(cfg.DrainDelay)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	shutdownErrc := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			err := srv.Shutdown(shutdownCtx)
+			if err != nil {
+				_ = srv.Close()
+				err = fmt.Errorf("shutting down %q: %w", srv.Addr, err)
+			}
+			shutdownErrc <- err
+		}(srv)
+	}
+	for range servers {
+		errs = append(errs, <-shutdownErrc)
+	}
+	for ; pending > 0; pending-- {
+		errs = append(errs, <-errc)
+	}
+
+	for i, hook := range cfg.ShutdownHooks {
+		if err := hook(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook #%d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,156 @@
+package otils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// waitForServer blocks until addr accepts connections.
+func waitForServer(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server at %s did not start", addr)
+}
+
+func TestServeDrainsAndRunsHooks(t *testing.T) {
+	health := new(Health)
+	started := make(chan bool)
+	addr := freeAddr(t)
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = io.WriteString(rw, "done")
+	})}
+
+	var order []string
+	cfg := &ServeConfig{
+		Health: health,
+		ShutdownHooks: []func(context.Context) error{
+			func(ctx context.Context) error {
+				if health.Report(ctx, false).Status != HealthFailing {
+					t.Error("expected readiness to fail while shutting down")
+				}
+				order = append(order, "first")
+				return nil
+			},
+			func(ctx context.Context) error {
+				order = append(order, "second")
+				return nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, cfg, srv) }()
+	waitForServer(t, addr)
+
+	bodyc := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + addr)
+		if err != nil {
+			t.Errorf("in-flight request failed: %v", err)
+			bodyc <- ""
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		bodyc <- string(body)
+	}()
+	<-started
+	cancel()
+
+	if err := <-served; err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got := <-bodyc; got != "done" {
+		t.Errorf("got=%q want=%q", got, "done")
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got=%v want=%v", order, want)
+	}
+}
+
+func TestServeStopsAllServersOnFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	okAddr := freeAddr(t)
+	ok := &http.Server{Addr: okAddr, Handler: http.NotFoundHandler()}
+	// The address is taken so this server fails to start.
+	taken := &http.Server{Addr: ln.Addr().String(), Handler: http.NotFoundHandler()}
+
+	hookErr := errors.New("flush failed")
+	cfg := &ServeConfig{ShutdownHooks: []func(context.Context) error{
+		func(ctx context.Context) error { return hookErr },
+	}}
+	err = Serve(context.Background(), cfg, ok, taken)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !errors.Is(err, hookErr) {
+		t.Errorf("expected the hook error in %v", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("expected the listen error in %v", err)
+	}
+	if conn, err := net.Dial("tcp", okAddr); err == nil {
+		conn.Close()
+		t.Error("expected the healthy server to be shut down")
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	addr := freeAddr(t)
+	release := make(chan bool)
+	defer close(release)
+	started := make(chan bool)
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, &ServeConfig{ShutdownTimeout: 50 * time.Millisecond}, srv) }()
+	waitForServer(t, addr)
+
+	go func() {
+		if res, err := http.Get("http://" + addr); err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+	cancel()
+
+	if err := <-served; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got=%v want=%v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,325 @@
+package otils
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignatureScheme describes how an HMAC signature over a message body
+// is carried in headers, so that different providers' webhook
+// conventions can be plugged into SignatureVerifier and SigningTransport.
+type SignatureScheme interface {
+	// Sign sets the headers carrying the signature of body made at ts.
+	Sign(header http.Header, secret, body []byte, ts time.Time)
+
+	// Verify checks the signature carried by header against body and
+	// returns the time the message was signed, or the zero time if the
+	// scheme carries none, along with the verified signature.
+	Verify(header http.Header, secret, body []byte) (ts time.Time, signature string, err error)
+}
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// HexSignatureScheme signs the body alone with HMAC-SHA256 and carries the
+// hex encoded signature in Header after Prefix, as GitHub webhooks do.
+// Having no timestamp, it offers no replay protection.
+type HexSignatureScheme struct {
+	Header string
+	Prefix string
+}
+
+// GitHubSignature is the scheme used by GitHub webhooks, whose
+// signature header looks like "X-Hub-Signature-256: sha256=<hex>".
+var GitHubSignature SignatureScheme = &HexSignatureScheme{Header: "X-Hub-Signature-256", Prefix: "sha256="}
+
+func (hss *HexSignatureScheme) Sign(header http.Header, secret, body []byte, ts time.Time) {
+	header.Set(hss.Header, hss.Prefix+hex.EncodeToString(hmacSHA256(secret, body)))
+}
+
+func (hss *HexSignatureScheme) Verify(header http.Header, secret, body []byte) (time.Time, string, error) {
+	value := header.Get(hss.Header)
+	if value == "" {
+		return time.Time{}, "", errMissingSignature
+	}
+	if !strings.HasPrefix(value, hss.Prefix) {
+		return time.Time{}, "", errInvalidSignature
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(value, hss.Prefix))
+	if err != nil || !hmac.Equal(sig, hmacSHA256(secret, body)) {
+		return time.Time{}, "", errInvalidSignature
+	}
+	return time.Time{}, value, nil
+}
+
+// TimestampedSignatureScheme signs "<unix timestamp>.<body>" with
+// HMAC-SHA256 and carries both in a single header as
+// "t=<unix timestamp>,v1=<hex signature>", as Stripe webhooks do.
+// Several v1 signatures may be present, for example during secret rotation.
+type TimestampedSignatureScheme struct {
+	Header string
+}
+
+// StripeSignature is the scheme used by Stripe webhooks.
+var StripeSignature SignatureScheme = &TimestampedSignatureScheme{Header: "Stripe-Signature"}
+
+func (tss *TimestampedSignatureScheme) Sign(header http.Header, secret, body []byte, ts time.Time) {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	sig := hmacSHA256(secret, timestampedPayload(unix, body))
+	header.Set(tss.Header, "t="+unix+",v1="+hex.EncodeToString(sig))
+}
+
+func (tss *TimestampedSignatureScheme) Verify(header http.Header, secret, body []byte) (time.Time, string, error) {
+	value := header.Get(tss.Header)
+	if value == "" {
+		return time.Time{}, "", errMissingSignature
+	}
+
+	var unix string
+	var candidates []string
+	for _, part := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			unix = val
+		case "v1":
+			candidates = append(candidates, val)
+		}
+	}
+	secs, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || len(candidates) == 0 {
+		return time.Time{}, "", errInvalidSignature
+	}
+
+	want := hmacSHA256(secret, timestampedPayload(unix, body))
+	for _, candidate := range candidates {
+		if sig, err := hex.DecodeString(candidate); err == nil && hmac.Equal(sig, want) {
+			return time.Unix(secs, 0), candidate, nil
+		}
+	}
+	return time.Time{}, "", errInvalidSignature
+}
+
+func timestampedPayload(unix string, body []byte) []byte {
+	payload := make([]byte, 0, len(unix)+1+len(body))
+	payload = append(payload, unix...)
+	payload = append(payload, '.')
+	return append(payload, body...)
+}
+
+func hmacSHA256(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// SignatureVerifier is a middleware that rejects requests whose body is not
+// signed with one of Secrets according to Scheme, responding with a 401
+// *CodedError. For timestamped schemes it also rejects signatures older
+// than Tolerance and signatures that were already seen, to prevent replays.
+// The verified body is handed on to the next handler intact.
+type SignatureVerifier struct {
+	// Secrets are the accepted signing secrets, more than
+	// one may be set while rotating secrets.
+	Secrets [][]byte
+
+	// Scheme is the header scheme, it defaults to StripeSignature.
+	Scheme SignatureScheme
+
+	// Tolerance is the maximum accepted difference between the time a
+	// message was signed and now, it defaults to 5 minutes.
+	Tolerance time.Duration
+
+	// MaxBodyBytes bounds the size of the body read for verification,
+	// it defaults to 1MB. Larger bodies are rejected with a 413.
+	MaxBodyBytes int64
+
+	next http.Handler
+	seen *replayCache
+}
+
+// SignatureVerifierMiddleware returns a handler that verifies
+// requests with sv before passing them on to next.
+func SignatureVerifierMiddleware(sv *SignatureVerifier, next http.Handler) http.Handler {
+	if sv == nil {
+		return next
+	}
+	copy := new(SignatureVerifier)
+	*copy = *sv
+	copy.next = next
+	copy.seen = &replayCache{seen: make(map[string]time.Time)}
+	return copy
+}
+
+var _ http.Handler = (*SignatureVerifier)(nil)
+
+func (sv *SignatureVerifier) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	limit := sv.MaxBodyBytes
+	if limit <= 0 {
+		limit = 1 << 20
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(req.Body, limit+1))
+		req.Body.Close()
+		if err != nil {
+			WriteCodedError(rw, req, MakeCodedError("failed to read body", http.StatusBadRequest))
+			return
+		}
+		if int64(len(body)) > limit {
+			WriteCodedError(rw, req, MakeCodedError("body too large", http.StatusRequestEntityTooLarge))
+			return
+		}
+	}
+
+	if err := sv.verify(req.Header, body, time.Now()); err != nil {
+		WriteCodedError(rw, req, MakeCodedError(err.Error(), http.StatusUnauthorized))
+		return
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if sv.next != nil {
+		sv.next.ServeHTTP(rw, req)
+	}
+}
+
+func (sv *SignatureVerifier) verify(header http.Header, body []byte, now time.Time) error {
+	scheme := sv.Scheme
+	if scheme == nil {
+		scheme = StripeSignature
+	}
+	tolerance := sv.Tolerance
+	if tolerance <= 0 {
+		tolerance = 5 * time.Minute
+	}
+
+	err := errMissingSignature
+	for _, secret := range sv.Secrets {
+		var ts time.Time
+		var sig string
+		if ts, sig, err = scheme.Verify(header, secret, body); err != nil {
+			continue
+		}
+		if ts.IsZero() {
+			return nil
+		}
+		if skew := now.Sub(ts); skew > tolerance || skew < -tolerance {
+			return fmt.Errorf("signature timestamp is outside the tolerance of %s", tolerance)
+		}
+		if sv.seen != nil && !sv.seen.add(sig, ts.Add(tolerance), now) {
+			return errors.New("signature was already used")
+		}
+		return nil
+	}
+	return err
+}
+
+// replayCache remembers signatures until they expire.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// expiries orders the keys of seen by when they expire,
+	// so that pruning only looks at those that did.
+	expiries replayExpiries
+}
+
+// add records key until expiry, it returns false if key was already recorded.
+func (rc *replayCache) add(key string, expiry, now time.Time) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for len(rc.expiries) > 0 && !rc.expiries[0].expiry.After(now) {
+		delete(rc.seen, heap.Pop(&rc.expiries).(replayExpiry).key)
+	}
+	if _, ok := rc.seen[key]; ok {
+		return false
+	}
+	rc.seen[key] = expiry
+	heap.Push(&rc.expiries, replayExpiry{key: key, expiry: expiry})
+	return true
+}
+
+type replayExpiry struct {
+	key    string
+	expiry time.Time
+}
+
+// replayExpiries is a min-heap of expiries, as signature
+// timestamps do not arrive in order.
+type replayExpiries []replayExpiry
+
+var _ heap.Interface = (*replayExpiries)(nil)
+
+func (re replayExpiries) Len() int           { return len(re) }
+func (re replayExpiries) Less(i, j int) bool { return re[i].expiry.Before(re[j].expiry) }
+func (re replayExpiries) Swap(i, j int)      { re[i], re[j] = re[j], re[i] }
+
+func (re *replayExpiries) Push(x interface{}) { *re = append(*re, x.(replayExpiry)) }
+
+func (re *replayExpiries) Pop() interface{} {
+	old := *re
+	last := old[len(old)-1]
+	*re = old[:len(old)-1]
+	return last
+}
+
+// SigningTransport is an http.RoundTripper that signs the body
+// of outgoing requests with Secret according to Scheme.
+type SigningTransport struct {
+	// Base is the underlying transport, if nil http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	Secret []byte
+
+	// Scheme is the header scheme, it defaults to StripeSignature.
+	Scheme SignatureScheme
+}
+
+var _ http.RoundTripper = (*SigningTransport)(nil)
+
+func (st *SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	scheme := st.Scheme
+	if scheme == nil {
+		scheme = StripeSignature
+	}
+	signed := req.Clone(req.Context())
+	scheme.Sign(signed.Header, st.Secret, body, time.Now())
+	if body != nil {
+		signed.Body = ioutil.NopCloser(bytes.NewReader(body))
+		signed.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		signed.ContentLength = int64(len(body))
+	}
+
+	base := st.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
@@ -0,0 +1,168 @@
+package otils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	secret := []byte("whsec_test")
+	body := `{"event":"charge.succeeded"}`
+	now := time.Now()
+
+	signed := func(scheme SignatureScheme, secret []byte, body string, ts time.Time) http.Header {
+		header := make(http.Header)
+		scheme.Sign(header, secret, []byte(body), ts)
+		return header
+	}
+
+	tests := []struct {
+		name     string
+		scheme   SignatureScheme
+		header   http.Header
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "valid timestamped signature",
+			header:   signed(StripeSignature, secret, body, now),
+			body:     body,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "valid hex signature",
+			scheme:   GitHubSignature,
+			header:   signed(GitHubSignature, secret, body, now),
+			body:     body,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "missing signature",
+			header:   http.Header{},
+			body:     body,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "tampered body",
+			header:   signed(StripeSignature, secret, body, now),
+			body:     `{"event":"charge.refunded"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong secret",
+			scheme:   GitHubSignature,
+			header:   signed(GitHubSignature, []byte("other"), body, now),
+			body:     body,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "stale timestamp",
+			header:   signed(StripeSignature, secret, body, now.Add(-time.Hour)),
+			body:     body,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "body too large",
+			header:   signed(StripeSignature, secret, strings.Repeat("a", 2<<20), now),
+			body:     strings.Repeat("a", 2<<20),
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBody string
+			handler := SignatureVerifierMiddleware(&SignatureVerifier{
+				Secrets: [][]byte{[]byte("old"), secret},
+				Scheme:  tt.scheme,
+			}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				blob, _ := ioutil.ReadAll(req.Body)
+				gotBody = string(blob)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tt.body))
+			req.Header = tt.header
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d body=%s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusOK && gotBody != tt.body {
+				t.Fatalf("next handler got body=%q want=%q", gotBody, tt.body)
+			}
+		})
+	}
+}
+
+func TestSignatureVerifierReplay(t *testing.T) {
+	secret := []byte("whsec_test")
+	handler := SignatureVerifierMiddleware(&SignatureVerifier{Secrets: [][]byte{secret}}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	header := make(http.Header)
+	StripeSignature.Sign(header, secret, []byte("{}"), time.Now())
+	for i, wantCode := range []int{http.StatusOK, http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader("{}"))
+		req.Header = header
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != wantCode {
+			t.Errorf("#%d gotCode=%d wantCode=%d", i, rec.Code, wantCode)
+		}
+	}
+}
+
+func TestReplayCachePrunesExpired(t *testing.T) {
+	rc := &replayCache{seen: make(map[string]time.Time)}
+	now := time.Date(2021, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+	// Keys arrive out of expiry order.
+	for i, key := range []string{"c", "a", "b"} {
+		expiry := now.Add(time.Duration(int(key[0]-'a')+1) * time.Minute)
+		if !rc.add(key, expiry, now) {
+			t.Fatalf("#%d: %q was not recorded", i, key)
+		}
+	}
+	if rc.add("b", now.Add(time.Hour), now) {
+		t.Fatal("expected a replayed key to be refused")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !rc.add("a", now.Add(time.Minute), now) {
+		t.Fatal("expected an expired key to be recorded again")
+	}
+	if got, want := len(rc.seen), 2; got != want || len(rc.expiries) != want {
+		t.Fatalf("got=%d entries (%d expiries) want=%d", got, len(rc.expiries), want)
+	}
+	if _, ok := rc.seen["b"]; ok {
+		t.Fatal("expected the expired key b to be pruned")
+	}
+}
+
+func TestSigningTransport(t *testing.T) {
+	secret := []byte("outbound")
+	handler := SignatureVerifierMiddleware(&SignatureVerifier{
+		Secrets: [][]byte{secret},
+		Scheme:  GitHubSignature,
+	}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		blob, _ := ioutil.ReadAll(req.Body)
+		_, _ = rw.Write(blob)
+	}))
+	tst := httptest.NewServer(handler)
+	defer tst.Close()
+
+	client := &http.Client{Transport: &SigningTransport{Secret: secret, Scheme: GitHubSignature}}
+	res, err := client.Post(tst.URL, "application/json", strings.NewReader(`{"ping":true}`))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer res.Body.Close()
+	blob, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(blob) != `{"ping":true}` {
+		t.Fatalf("got status=%d body=%s", res.StatusCode, blob)
+	}
+}
@@ -0,0 +1,340 @@
+package otils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEEvent is an event of a Server-Sent Events stream.
+type SSEEvent struct {
+	// ID lets clients resume the stream after it, through the
+	// Last-Event-ID header that browsers send when reconnecting.
+	ID string
+
+	// Event is the event type, clients receive events without
+	// one through EventSource.onmessage.
+	Event string
+
+	// Data is the payload, it may span multiple lines.
+	Data string
+
+	// Retry if set tells clients how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+var errSSEField = errors.New("otils: SSE id and event fields cannot contain newlines")
+
+// appendSSEEvent appends the wire format of ev to b. Every line of Data
+// goes in a data field of its own, with "\r\n", "\r" and "\n" all being
+// line endings, so that payloads cannot inject fields of their own.
+func appendSSEEvent(b []byte, ev *SSEEvent) ([]byte, error) {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return b, errSSEField
+	}
+	if ev.ID != "" {
+		b = append(b, "id: "...)
+		b = append(b, ev.ID...)
+		b = append(b, '\n')
+	}
+	if ev.Event != "" {
+		b = append(b, "event: "...)
+		b = append(b, ev.Event...)
+		b = append(b, '\n')
+	}
+	if ev.Retry > 0 {
+		b = append(b, "retry: "...)
+		b = strconv.AppendInt(b, ev.Retry.Milliseconds(), 10)
+		b = append(b, '\n')
+	}
+	data := strings.ReplaceAll(ev.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b = append(b, "data: "...)
+		b = append(b, line...)
+		b = append(b, '\n')
+	}
+	return append(b, '\n'), nil
+}
+
+// SSEReplayBuffer keeps the latest events published on a stream so that
+// clients reconnecting with a Last-Event-ID get the events they missed.
+// It is safe for concurrent use and meant to be shared by all the
+// SSEWriters of a stream.
+type SSEReplayBuffer struct {
+	mu     sync.Mutex
+	size   int
+	events []*SSEEvent
+	lastID uint64
+}
+
+// NewSSEReplayBuffer returns a buffer holding the latest size events.
+func NewSSEReplayBuffer(size int) *SSEReplayBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &SSEReplayBuffer{size: size}
+}
+
+// Add records ev, giving it the next sequential ID if it has none,
+// and returns it for sending to the clients currently connected.
+func (rb *SSEReplayBuffer) Add(ev *SSEEvent) *SSEEvent {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if ev.ID == "" {
+		rb.lastID++
+		ev.ID = strconv.FormatUint(rb.lastID, 10)
+	}
+	if len(rb.events) == rb.size {
+		copy(rb.events, rb.events[1:])
+		rb.events = rb.events[:len(rb.events)-1]
+	}
+	rb.events = append(rb.events, ev)
+	return ev
+}
+
+// Since returns the events added after the one with lastID. If that
+// event is no longer buffered all the buffered events are returned and
+// ok is false, as some were missed.
+func (rb *SSEReplayBuffer) Since(lastID string) (events []*SSEEvent, ok bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	for i := len(rb.events) - 1; i >= 0; i-- {
+		if rb.events[i].ID == lastID {
+			return append([]*SSEEvent(nil), rb.events[i+1:]...), true
+		}
+	}
+	return append([]*SSEEvent(nil), rb.events...), false
+}
+
+// SSE configures the streams started by NewSSEWriter.
+type SSE struct {
+	// Heartbeat is how often a comment is sent on idle streams to keep
+	// proxies from timing them out, it defaults to 15s and a negative
+	// value disables heartbeats.
+	Heartbeat time.Duration
+
+	// Retry if set is sent at the start of the stream to tell
+	// clients how long to wait before reconnecting.
+	Retry time.Duration
+
+	// Replay if set is used to resend the events that clients
+	// reconnecting with a Last-Event-ID missed.
+	Replay *SSEReplayBuffer
+}
+
+// SSEWriter writes a Server-Sent Events stream. Its methods are safe
+// for concurrent use and fail once the client has disconnected.
+type SSEWriter struct {
+	rw     http.ResponseWriter
+	rc     *http.ResponseController
+	ctx    context.Context
+	lastID string
+
+	mu     sync.Mutex
+	buf    []byte
+	err    error
+	closed bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSSEWriter starts a Server-Sent Events stream in response to req,
+// replaying the events missed by reconnecting clients and sending
+// heartbeats until Close is called, which must be before the handler
+// returns. Headers already set, e.g. by CORSMiddleware, are kept. It
+// fails with a *CodedError, before anything is written, if rw cannot be
+// flushed as happens behind TimeoutMiddleware unless the route's timeout
+// is disabled. A nil SSE uses the default settings.
+// Sample usage is:
+//
+//	func events(rw http.ResponseWriter, req *http.Request) {
+//		sw, err := otils.NewSSEWriter(rw, req, &otils.SSE{Replay: replay})
+//		if err != nil {
+//			otils.WriteCodedError(rw, req, err)
+//			return
+//		}
+//		defer sw.Close()
+//
+//		updates := hub.Subscribe()
+//		defer hub.Unsubscribe(updates)
+//		_ = sw.Stream(updates)
+//	}
+//
+// with publishers sending replay.Add(ev) to subscribers.
+func NewSSEWriter(rw http.ResponseWriter, req *http.Request, cfg *SSE) (*SSEWriter, error) {
+	if cfg == nil {
+		cfg = new(SSE)
+	}
+	sw := &SSEWriter{
+		rw:     rw,
+		rc:     http.NewResponseController(rw),
+		ctx:    req.Context(),
+		lastID: req.Header.Get("Last-Event-ID"),
+		stop:   make(chan struct{}),
+	}
+
+	if !canFlushResponse(rw) {
+		return nil, MakeCodedError("streaming is not supported by the server", http.StatusInternalServerError)
+	}
+
+	header := rw.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream.
+	header.Set("X-Accel-Buffering", "no")
+	header.Del("Content-Length")
+	rw.WriteHeader(http.StatusOK)
+
+	sw.mu.Lock()
+	sw.writeLocked()
+	if cfg.Retry > 0 {
+		sw.buf = append(sw.buf[:0], "retry: "...)
+		sw.buf = strconv.AppendInt(sw.buf, cfg.Retry.Milliseconds(), 10)
+		sw.buf = append(sw.buf, "\n\n"...)
+		sw.writeLocked()
+	}
+	if cfg.Replay != nil && sw.lastID != "" {
+		missed, _ := cfg.Replay.Since(sw.lastID)
+		for _, ev := range missed {
+			if err := sw.sendLocked(ev); err != nil && err != errSSEField {
+				break
+			}
+		}
+	}
+	err := sw.err
+	sw.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	heartbeat := cfg.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = 15 * time.Second
+	}
+	if heartbeat > 0 {
+		sw.wg.Add(1)
+		go sw.heartbeat(heartbeat)
+	}
+	return sw, nil
+}
+
+// canFlushResponse reports whether rw, or a ResponseWriter that it wraps,
+// can be flushed, checking in the way http.ResponseController does.
+func canFlushResponse(rw http.ResponseWriter) bool {
+	for {
+		switch w := rw.(type) {
+		case http.Flusher:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			rw = w.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// LastEventID returns the Last-Event-ID sent by a reconnecting client,
+// for streams that resume from their own storage rather than a replay buffer.
+func (sw *SSEWriter) LastEventID() string { return sw.lastID }
+
+// Done is closed once the client disconnects.
+func (sw *SSEWriter) Done() <-chan struct{} { return sw.ctx.Done() }
+
+// Send writes ev to the client.
+func (sw *SSEWriter) Send(ev *SSEEvent) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.sendLocked(ev)
+}
+
+func (sw *SSEWriter) sendLocked(ev *SSEEvent) error {
+	if sw.err != nil {
+		return sw.err
+	}
+	buf, err := appendSSEEvent(sw.buf[:0], ev)
+	if err != nil {
+		// Only this event is rejected, the stream is still usable.
+		return err
+	}
+	sw.buf = buf
+	sw.writeLocked()
+	return sw.err
+}
+
+// writeLocked writes and flushes sw.buf, recording the first failure
+// after which the stream is unusable.
+func (sw *SSEWriter) writeLocked() {
+	if sw.err != nil {
+		return
+	}
+	if sw.err = sw.ctx.Err(); sw.err != nil {
+		return
+	}
+	if _, sw.err = sw.rw.Write(sw.buf); sw.err != nil {
+		return
+	}
+	sw.err = sw.rc.Flush()
+}
+
+// Stream sends the events received from events until it is closed
+// or the client disconnects, returning nil in the former case.
+func (sw *SSEWriter) Stream(events <-chan *SSEEvent) error {
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := sw.Send(ev); err != nil {
+				return err
+			}
+		case <-sw.ctx.Done():
+			return sw.ctx.Err()
+		}
+	}
+}
+
+func (sw *SSEWriter) heartbeat(every time.Duration) {
+	defer sw.wg.Done()
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sw.stop:
+			return
+		case <-sw.ctx.Done():
+			return
+		case <-ticker.C:
+			// A comment, which clients ignore.
+			sw.mu.Lock()
+			sw.buf = append(sw.buf[:0], ":\n\n"...)
+			sw.writeLocked()
+			sw.mu.Unlock()
+		}
+	}
+}
+
+var errSSEClosed = errors.New("otils: SSE stream is closed")
+
+// Close stops the heartbeats and makes further sends fail.
+func (sw *SSEWriter) Close() error {
+	sw.mu.Lock()
+	if !sw.closed {
+		sw.closed = true
+		close(sw.stop)
+	}
+	if sw.err == nil {
+		sw.err = errSSEClosed
+	}
+	sw.mu.Unlock()
+	sw.wg.Wait()
+	return nil
+}
@@ -0,0 +1,252 @@
+package otils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendSSEEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		ev      *SSEEvent
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "data only",
+			ev:   &SSEEvent{Data: "hello"},
+			want: "data: hello\n\n",
+		},
+		{
+			name: "all fields",
+			ev:   &SSEEvent{ID: "7", Event: "price", Data: `{"usd":1}`, Retry: 2500 * time.Millisecond},
+			want: "id: 7\nevent: price\nretry: 2500\ndata: {\"usd\":1}\n\n",
+		},
+		{
+			name: "multi-line data",
+			ev:   &SSEEvent{Data: "a\nb\r\nc\rd"},
+			want: "data: a\ndata: b\ndata: c\ndata: d\n\n",
+		},
+		{
+			name: "field injection in data",
+			ev:   &SSEEvent{Data: "x\n\nevent: admin\ndata: y"},
+			want: "data: x\ndata: \ndata: event: admin\ndata: data: y\n\n",
+		},
+		{
+			name: "empty data",
+			ev:   &SSEEvent{Event: "ping"},
+			want: "event: ping\ndata: \n\n",
+		},
+		{
+			name:    "newline in id",
+			ev:      &SSEEvent{ID: "1\ndata: x"},
+			wantErr: true,
+		},
+		{
+			name:    "newline in event",
+			ev:      &SSEEvent{Event: "a\rb"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appendSSEEvent(nil, tt.ev)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got=%q want=%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSEReplayBuffer(t *testing.T) {
+	rb := NewSSEReplayBuffer(3)
+	for _, data := range []string{"a", "b", "c", "d"} {
+		rb.Add(&SSEEvent{Data: data})
+	}
+	rb.Add(&SSEEvent{ID: "custom", Data: "e"})
+
+	ids := func(events []*SSEEvent) string {
+		var parts []string
+		for _, ev := range events {
+			parts = append(parts, ev.ID+"="+ev.Data)
+		}
+		return strings.Join(parts, ",")
+	}
+
+	tests := []struct {
+		lastID string
+		want   string
+		wantOK bool
+	}{
+		0: {lastID: "3", want: "4=d,custom=e", wantOK: true},
+		1: {lastID: "custom", want: "", wantOK: true},
+		// Evicted, so everything that is left is replayed.
+		2: {lastID: "1", want: "3=c,4=d,custom=e", wantOK: false},
+	}
+	for i, tt := range tests {
+		events, ok := rb.Since(tt.lastID)
+		if got := ids(events); got != tt.want || ok != tt.wantOK {
+			t.Errorf("#%d got=%q ok=%t want=%q ok=%t", i, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSSEWriter(t *testing.T) {
+	replay := NewSSEReplayBuffer(10)
+	for _, data := range []string{"one", "two", "three"} {
+		replay.Add(&SSEEvent{Event: "update", Data: data})
+	}
+
+	updates := make(chan *SSEEvent)
+	streamErr := make(chan error, 1)
+	handler := CORSMiddleware(&CORS{Origins: []string{"https://dash.orijtech.com"}},
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			sw, err := NewSSEWriter(rw, req, &SSE{
+				Heartbeat: 20 * time.Millisecond,
+				Retry:     3 * time.Second,
+				Replay:    replay,
+			})
+			if err != nil {
+				WriteCodedError(rw, req, err)
+				return
+			}
+			defer sw.Close()
+			streamErr <- sw.Stream(updates)
+		}))
+	cst := httptest.NewServer(handler)
+	defer cst.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, cst.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer res.Body.Close()
+
+	for header, want := range map[string]string{
+		"Content-Type":                "text/event-stream",
+		"Cache-Control":               "no-cache",
+		"Access-Control-Allow-Origin": "https://dash.orijtech.com",
+	} {
+		if got := res.Header.Get(header); got != want {
+			t.Errorf("%s: got=%q want=%q", header, got, want)
+		}
+	}
+
+	br := bufio.NewReader(res.Body)
+	readFrame := func() string {
+		var lines []string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading the stream: %v", err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	readEvent := func() string {
+		for {
+			if frame := readFrame(); frame != ":\n" {
+				return frame
+			}
+		}
+	}
+
+	wantEvents := []string{
+		"retry: 3000\n",
+		"id: 2\nevent: update\ndata: two\n",
+		"id: 3\nevent: update\ndata: three\n",
+	}
+	for i, want := range wantEvents {
+		if got := readEvent(); got != want {
+			t.Fatalf("#%d got=%q want=%q", i, got, want)
+		}
+	}
+
+	updates <- &SSEEvent{ID: "4", Data: "live\nupdate"}
+	if got, want := readEvent(), "id: 4\ndata: live\ndata: update\n"; got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+
+	// Nothing more is sent so the next frame is a heartbeat.
+	if got := readFrame(); got != ":\n" {
+		t.Fatalf("expected a heartbeat, got %q", got)
+	}
+
+	cancel()
+	select {
+	case err := <-streamErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the stream to stop with context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not stop after the client disconnected")
+	}
+}
+
+func TestSSEWriterNotFlushable(t *testing.T) {
+	var gotErr error
+	handler := TimeoutMiddleware(&Timeout{Default: time.Second},
+		http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, gotErr = NewSSEWriter(rw, req, nil)
+			if gotErr != nil {
+				WriteCodedError(rw, req, gotErr)
+			}
+		}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if gotErr == nil {
+		t.Fatal("expected an error")
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("gotCode=%d wantCode=%d", rec.Code, http.StatusInternalServerError)
+	}
+	if got := rec.Header().Get("Content-Type"); strings.HasPrefix(got, "text/event-stream") {
+		t.Fatalf("the stream should not have been started, got Content-Type %q", got)
+	}
+}
+
+func TestSSEWriterClose(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw, err := NewSSEWriter(rec, httptest.NewRequest(http.MethodGet, "/events", nil), &SSE{Heartbeat: -1})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := sw.Send(&SSEEvent{Event: "bad\nevent"}); err == nil {
+		t.Fatal("expected invalid events to be rejected")
+	}
+	if err := sw.Send(&SSEEvent{Data: "ok"}); err != nil {
+		t.Fatalf("the stream should survive rejected events: %v", err)
+	}
+	_ = sw.Close()
+	_ = sw.Close()
+	if err := sw.Send(&SSEEvent{Data: "late"}); err == nil {
+		t.Fatal("expected sends after Close to fail")
+	}
+	if got, want := rec.Body.String(), "data: ok\n\n"; got != want {
+		t.Fatalf("got=%q want=%q", got, want)
+	}
+}
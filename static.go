@@ -0,0 +1,327 @@
+package otils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Static serves files from an fs.FS such as an embed.FS, typically the
+// build output of a single-page app. Unlike http.FileServer it never lists
+// directories, refuses dotfiles other than those under .well-known, and
+// serves "name.gz" with "Content-Encoding: gzip" in place of "name" to
+// clients that accept gzip. Files whose names carry a content hash, like
+// "app.3f9a1c2e.js" or "index-BdV3zq2L.js", are cached by clients for a
+// year as they never change, whereas everything else is revalidated.
+// Request paths are cleaned and must be valid fs.FS names, so they cannot
+// climb out of the file system, but note that an os.DirFS follows the
+// symbolic links within it.
+type Static struct {
+	FS fs.FS
+
+	// Index is the file served for directories, it defaults to "index.html".
+	Index string
+
+	// SPA serves the root Index for paths that match no file and look
+	// like page navigations rather than assets, so that the app's router
+	// can handle them.
+	SPA bool
+
+	// MaxAge if set lets clients cache files that are not immutable for
+	// that long instead of revalidating them. Index files are always
+	// revalidated so that new deployments are picked up.
+	MaxAge time.Duration
+
+	// Immutable reports whether a file never changes and can be cached
+	// forever, it defaults to checking for a content hash in the name.
+	Immutable func(name string) bool
+
+	etags sync.Map
+}
+
+// NewStaticHandler returns a handler serving the files of s.FS, checking
+// that it has a file system and, for an SPA, an index file. A *Static can
+// also be used as a handler directly.
+// Sample usage is:
+//
+//	//go:embed dist
+//	var dist embed.FS
+//
+//	func main() {
+//		assets, _ := fs.Sub(dist, "dist")
+//		static, err := otils.NewStaticHandler(&otils.Static{FS: assets, SPA: true})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		mux := http.NewServeMux()
+//		mux.Handle("/api/", api)
+//		mux.Handle("/", static)
+//	}
+func NewStaticHandler(s *Static) (http.Handler, error) {
+	if s == nil || s.FS == nil {
+		return nil, errors.New("otils: static handler needs a file system")
+	}
+	copy := &Static{FS: s.FS, Index: s.Index, SPA: s.SPA, MaxAge: s.MaxAge, Immutable: s.Immutable}
+	if copy.SPA {
+		if _, err := fs.Stat(copy.FS, copy.index()); err != nil {
+			return nil, fmt.Errorf("otils: SPA index: %w", err)
+		}
+	}
+	return copy, nil
+}
+
+var _ http.Handler = (*Static)(nil)
+
+func (s *Static) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		WriteCodedError(rw, req, MakeCodedError("method not allowed", http.StatusMethodNotAllowed))
+		return
+	}
+	if s.FS == nil {
+		WriteCodedError(rw, req, MakeCodedError("not found", http.StatusNotFound))
+		return
+	}
+
+	name, ok := staticName(req.URL.Path)
+	var dir bool
+	if ok {
+		name, dir, ok = s.resolve(name)
+	}
+	if ok && dir && !strings.HasSuffix(req.URL.Path, "/") {
+		// Like http.FileServer, so that relative links in the index work.
+		target := path.Base(req.URL.Path) + "/"
+		if req.URL.RawQuery != "" {
+			target += "?" + req.URL.RawQuery
+		}
+		http.Redirect(rw, req, target, http.StatusMovedPermanently)
+		return
+	}
+	if !ok {
+		if !s.SPA || !isNavigation(req) {
+			WriteCodedError(rw, req, MakeCodedError("not found", http.StatusNotFound))
+			return
+		}
+		name = s.index()
+	}
+	s.serveFile(rw, req, name)
+}
+
+// staticName maps a URL path to a name in the file system, refusing those
+// that could escape it or that refer to hidden files.
+func staticName(urlPath string) (string, bool) {
+	// Backslashes are separators for os.DirFS on Windows.
+	if strings.ContainsAny(urlPath, "\\\x00") {
+		return "", false
+	}
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return ".", true
+	}
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	for i, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") && !(i == 0 && segment == ".well-known") {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+func (s *Static) index() string {
+	if s.Index != "" {
+		return s.Index
+	}
+	return "index.html"
+}
+
+func (s *Static) immutable(name string) bool {
+	if s.Immutable != nil {
+		return s.Immutable(name)
+	}
+	return hashedAssetName(name)
+}
+
+// resolve returns the regular file to serve for name, the Index
+// file for directories, and whether there is one.
+func (s *Static) resolve(name string) (file string, dir, ok bool) {
+	fi, err := fs.Stat(s.FS, name)
+	if err != nil {
+		return "", false, false
+	}
+	if fi.IsDir() {
+		dir = true
+		name = path.Join(name, s.index())
+		if fi, err = fs.Stat(s.FS, name); err != nil {
+			return "", false, false
+		}
+	}
+	return name, dir, fi.Mode().IsRegular()
+}
+
+// isNavigation reports whether req is for a page rather than an asset,
+// which is the case of HTML requests and of paths without an extension.
+func isNavigation(req *http.Request) bool {
+	for _, spec := range ParseAccept(req.Header.Values("Accept")...) {
+		if strings.EqualFold(spec.Value, "text/html") && spec.Q > 0 {
+			return true
+		}
+	}
+	return path.Ext(req.URL.Path) == ""
+}
+
+func (s *Static) serveFile(rw http.ResponseWriter, req *http.Request, name string) {
+	header := rw.Header()
+	switch {
+	case path.Base(name) == s.index():
+		header.Set("Cache-Control", "no-cache")
+	case s.immutable(name):
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	case s.MaxAge > 0:
+		header.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.MaxAge.Seconds())))
+	default:
+		header.Set("Cache-Control", "no-cache")
+	}
+
+	served, gzipped := name, false
+	if fi, err := fs.Stat(s.FS, name+".gz"); err == nil && fi.Mode().IsRegular() {
+		header.Add("Vary", "Accept-Encoding")
+		if NegotiateEncoding(req, "gzip", "identity") == "gzip" {
+			served, gzipped = name+".gz", true
+		}
+	}
+
+	f, err := s.FS.Open(served)
+	if err != nil {
+		WriteCodedError(rw, req, MakeCodedError("not found", http.StatusNotFound))
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		WriteCodedError(rw, req, err)
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		blob, err := io.ReadAll(f)
+		if err != nil {
+			WriteCodedError(rw, req, err)
+			return
+		}
+		content = bytes.NewReader(blob)
+	}
+
+	// Files without modification times, as in an embed.FS, get an ETag
+	// instead so that revalidation still works.
+	if fi.ModTime().IsZero() {
+		etag, err := s.etag(served, content)
+		if err != nil {
+			WriteCodedError(rw, req, err)
+			return
+		}
+		header.Set("ETag", etag)
+	}
+
+	if gzipped {
+		header.Set("Content-Encoding", "gzip")
+	}
+	// The name of the uncompressed file gives the Content-Type.
+	http.ServeContent(rw, req, name, fi.ModTime(), content)
+}
+
+// etag returns the ETag of the file name whose content is r, caching it
+// since the files of a file system without modification times are not
+// expected to change.
+func (s *Static) etag(name string, r io.ReadSeeker) (string, error) {
+	if etag, ok := s.etags.Load(name); ok {
+		return etag.(string), nil
+	}
+	blob, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := computeETag(blob, false)
+	s.etags.Store(name, etag)
+	return etag, nil
+}
+
+// hashedAssetName reports whether the file name carries a content hash
+// added by bundlers in a segment of its own after the name: either a
+// dotted segment of 8 to 20 hexadecimal digits mixing digits and letters,
+// as in "main.3f9a1c2e.chunk.js", or a dashed suffix of 8 to 12 base64url
+// characters mixing digits, upper and lower case letters, as in
+// "index-BdV3zq2L.js". Names that merely end in a number or a version,
+// like "report-20240101.pdf" or "Roboto-Bold2023.woff2", are not hashed.
+func hashedAssetName(name string) bool {
+	base := path.Base(name)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	parts := strings.Split(base, ".")
+	for _, part := range parts[1:] {
+		if len(part) >= 8 && len(part) <= 20 && isHexHash(part) {
+			return true
+		}
+	}
+	// base64url hashes can themselves contain dashes.
+	for n := 8; n <= 12; n++ {
+		if i := len(base) - n - 1; i > 0 && base[i] == '-' && isBase64URLHash(base[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHexHash(s string) bool {
+	var digits, letters bool
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
+			letters = true
+		default:
+			return false
+		}
+	}
+	return digits && letters
+}
+
+// isBase64URLHash reports whether s looks random rather than like a word
+// followed by a number, by requiring digits, upper and lower case letters
+// and that it switches between those at least three times.
+func isBase64URLHash(s string) bool {
+	var digits, upper, lower bool
+	var class, prev rune
+	changes := 0
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits, class = true, '0'
+		case r >= 'A' && r <= 'Z':
+			upper, class = true, 'A'
+		case r >= 'a' && r <= 'z':
+			lower, class = true, 'a'
+		case r == '-' || r == '_':
+			class = '-'
+		default:
+			return false
+		}
+		if i > 0 && class != prev {
+			changes++
+		}
+		prev = class
+	}
+	return digits && upper && lower && changes >= 3
+}
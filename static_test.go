@@ -0,0 +1,267 @@
+package otils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = io.WriteString(zw, s)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStaticHandler(t *testing.T) {
+	const index = "<!doctype html><div id=app></div>"
+	const app = "console.log('app')"
+	fsys := fstest.MapFS{
+		"index.html":                  {Data: []byte(index)},
+		"favicon.ico":                 {Data: []byte("icon")},
+		"assets/index-BdV3zq2L.js":    {Data: []byte(app)},
+		"assets/index-BdV3zq2L.js.gz": {Data: gzipped(t, app)},
+		"assets/main.3f9a1c2e.css":    {Data: []byte("body{}")},
+		"assets/jquery-datepicker.js": {Data: []byte("datepicker")},
+		"docs/index.html":             {Data: []byte("docs")},
+		"empty/readme.txt":            {Data: []byte("readme")},
+		".env":                        {Data: []byte("SECRET=1")},
+		"assets/.git/config.json":     {Data: []byte("git")},
+		".well-known/security.txt":    {Data: []byte("Contact: security@orijtech.com")},
+		"robots.txt":                  {Data: []byte("User-agent: *")},
+		"robots.txt.gz":               {Data: gzipped(t, "User-agent: *")},
+	}
+	handler, err := NewStaticHandler(&Static{FS: fsys, SPA: true})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	const immutable = "public, max-age=31536000, immutable"
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		header       http.Header
+		wantCode     int
+		wantBody     string
+		wantType     string
+		wantCache    string
+		wantEncoding string
+		wantLocation string
+	}{
+		{name: "root index", path: "/", wantCode: 200, wantBody: index, wantType: "text/html; charset=utf-8", wantCache: "no-cache"},
+		{name: "hashed asset", path: "/assets/main.3f9a1c2e.css", wantCode: 200, wantBody: "body{}", wantType: "text/css; charset=utf-8", wantCache: immutable},
+		{name: "unhashed asset", path: "/assets/jquery-datepicker.js", wantCode: 200, wantBody: "datepicker", wantCache: "no-cache"},
+		{
+			name:     "precompressed",
+			path:     "/assets/index-BdV3zq2L.js",
+			header:   http.Header{"Accept-Encoding": {"br, gzip;q=0.8"}},
+			wantCode: 200, wantBody: app, wantType: "text/javascript; charset=utf-8",
+			wantCache: immutable, wantEncoding: "gzip",
+		},
+		{
+			name:     "gzip refused",
+			path:     "/assets/index-BdV3zq2L.js",
+			header:   http.Header{"Accept-Encoding": {"gzip;q=0, br"}},
+			wantCode: 200, wantBody: app, wantCache: immutable,
+		},
+		{name: "no encodings", path: "/robots.txt", wantCode: 200, wantBody: "User-agent: *", wantCache: "no-cache"},
+		{name: "directory index", path: "/docs/", wantCode: 200, wantBody: "docs", wantCache: "no-cache"},
+		{name: "directory redirect", path: "/docs?v=1", wantCode: http.StatusMovedPermanently, wantLocation: "/docs/?v=1"},
+		{name: "no directory listing", path: "/empty/", wantCode: 200, wantBody: index},
+		{name: "spa route", path: "/settings/profile", wantCode: 200, wantBody: index, wantCache: "no-cache"},
+		{
+			name:     "spa route with extension",
+			path:     "/users/jane.doe",
+			header:   http.Header{"Accept": {"text/html,application/xhtml+xml"}},
+			wantCode: 200, wantBody: index,
+		},
+		{name: "missing asset", path: "/assets/missing.js", wantCode: 404},
+		{name: "dotfile", path: "/.env", wantCode: 404},
+		{name: "nested dotfile", path: "/assets/.git/config.json", wantCode: 404},
+		// Hidden files are never served, but this is an SPA route.
+		{name: "dotfile navigation", path: "/assets/.git/config", wantCode: 200, wantBody: index},
+		{name: "well-known", path: "/.well-known/security.txt", wantCode: 200, wantBody: "Contact: security@orijtech.com"},
+		{name: "traversal", path: "/assets/../../.env", wantCode: 404},
+		{name: "backslash", path: `/assets\..\.env`, wantCode: 404},
+		{name: "nul byte", path: "/index.html\x00.js", wantCode: 404},
+		{name: "head", method: http.MethodHead, path: "/favicon.ico", wantCode: 200, wantBody: ""},
+		{name: "post", method: http.MethodPost, path: "/index.html", wantCode: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			req.URL.Path, req.URL.RawQuery, _ = strings.Cut(tt.path, "?")
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d body=%s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusNotFound || tt.wantCode == http.StatusMethodNotAllowed {
+				var body codedErrorBody
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode {
+					t.Fatalf("expected a coded error, got %s", rec.Body)
+				}
+				return
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location: got=%q want=%q", got, tt.wantLocation)
+			}
+			if tt.wantLocation != "" {
+				return
+			}
+
+			body := rec.Body.Bytes()
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding: got=%q want=%q", got, tt.wantEncoding)
+			}
+			if tt.wantEncoding == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+				body, _ = io.ReadAll(zr)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("got=%q want=%q", body, tt.wantBody)
+			}
+			if tt.wantType != "" {
+				if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+					t.Errorf("Content-Type: got=%q want=%q", got, tt.wantType)
+				}
+			}
+			if tt.wantCache != "" {
+				if got := rec.Header().Get("Cache-Control"); got != tt.wantCache {
+					t.Errorf("Cache-Control: got=%q want=%q", got, tt.wantCache)
+				}
+			}
+		})
+	}
+}
+
+func TestStaticHandlerRevalidation(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte("app")},
+		"app.js.gz": {Data: gzipped(t, "app")},
+	}
+	handler, err := NewStaticHandler(&Static{FS: fsys})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	plain, compressed := get("", ""), get("gzip", "")
+	plainETag, gzipETag := plain.Header().Get("ETag"), compressed.Header().Get("ETag")
+	if plainETag == "" || gzipETag == "" || plainETag == gzipETag {
+		t.Fatalf("expected distinct ETags per encoding, got %q and %q", plainETag, gzipETag)
+	}
+	if got := plain.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Fatalf("Vary: got=%q want=%q", got, "Accept-Encoding")
+	}
+
+	if rec := get("", plainETag); rec.Code != http.StatusNotModified {
+		t.Fatalf("gotCode=%d wantCode=%d", rec.Code, http.StatusNotModified)
+	}
+	if rec := get("", gzipETag); rec.Code != http.StatusOK {
+		t.Fatalf("the gzip ETag must not match the plain file, gotCode=%d", rec.Code)
+	}
+}
+
+func TestNewStaticHandlerErrors(t *testing.T) {
+	if _, err := NewStaticHandler(nil); err == nil {
+		t.Error("expected an error without a file system")
+	}
+	if _, err := NewStaticHandler(&Static{FS: fstest.MapFS{}, SPA: true}); err == nil {
+		t.Error("expected an error for an SPA without an index")
+	}
+}
+
+func TestStaticWithoutConstructor(t *testing.T) {
+	handler := &Static{FS: fstest.MapFS{
+		"index.html":             {Data: []byte("<html></html>")},
+		"assets/app.3f9a1c2e.js": {Data: []byte("app()")},
+	}}
+	tests := []struct {
+		path      string
+		wantCode  int
+		wantCache string
+	}{
+		{path: "/", wantCode: 200, wantCache: "no-cache"},
+		{path: "/assets/app.3f9a1c2e.js", wantCode: 200, wantCache: "public, max-age=31536000, immutable"},
+		{path: "/missing.js", wantCode: 404},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: gotCode=%d wantCode=%d", tt.path, rec.Code, tt.wantCode)
+		}
+		if got := rec.Header().Get("Cache-Control"); tt.wantCache != "" && got != tt.wantCache {
+			t.Errorf("%s: gotCache=%q wantCache=%q", tt.path, got, tt.wantCache)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	new(Static).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without a file system: gotCode=%d wantCode=%d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestHashedAssetName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"assets/index-BdV3zq2L.js", true},
+		{"static/js/main.3f9a1c2e.chunk.js", true},
+		{"app.0123456789abcdef.css", true},
+		{"jquery-datepicker.js", false},
+		{"bootstrap4.min.css", false},
+		{"report-20240101.pdf", false},
+		{"index.html", false},
+		{"assets/index-D_a3-Xk9.js", true},
+		{"logo.deadbeef1.svg", true},
+		{"Report2024Q1.pdf", false},
+		{"fonts/Roboto-Bold2023.woff2", false},
+		{"fonts/Inter-Variable.woff2", false},
+		{"3f9a1c2e.js", false},
+		{"BdV3zq2L.js", false},
+		{"vendor.0123456789abcdef0123456789.js", false},
+	}
+	for _, tt := range tests {
+		if got := hashedAssetName(tt.name); got != tt.want {
+			t.Errorf("%s: got=%t want=%t", tt.name, got, tt.want)
+		}
+	}
+}
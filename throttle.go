@@ -0,0 +1,144 @@
+package otils
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitTransport is an http.RoundTripper that paces outgoing requests
+// with a token bucket per destination host so that bursts do not exceed
+// what third-party APIs tolerate. Requests over the limit wait for a token
+// until their context is done.
+// Sample usage is:
+//
+//	client := &http.Client{
+//		Transport: &otils.RateLimitTransport{RequestsPerSecond: 5, Burst: 10},
+//	}
+type RateLimitTransport struct {
+	// Base is the underlying transport, if nil http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// RequestsPerSecond is the sustained rate allowed for each host.
+	// If zero or negative, requests are not rate limited.
+	RequestsPerSecond float64
+
+	// Burst is the number of requests that may be sent at once
+	// to a host, it defaults to 1.
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+var _ http.RoundTripper = (*RateLimitTransport)(nil)
+
+func (rlt *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rlt.RequestsPerSecond > 0 {
+		if err := rlt.wait(req); err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+	}
+	base := rlt.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// closeRequestBody closes the body of a request that will not be sent,
+// as http.RoundTripper implementations must even when they fail.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
+
+func (rlt *RateLimitTransport) wait(req *http.Request) error {
+	bucket := rlt.bucket(req.URL.Host)
+	ctx := req.Context()
+	for {
+		ok, wait := bucket.take(time.Now())
+		if ok {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (rlt *RateLimitTransport) bucket(host string) *tokenBucket {
+	rlt.mu.Lock()
+	defer rlt.mu.Unlock()
+
+	if rlt.buckets == nil {
+		rlt.buckets = make(map[string]*tokenBucket)
+	}
+	bucket, ok := rlt.buckets[host]
+	if !ok {
+		bucket = newTokenBucket(rlt.RequestsPerSecond, rlt.Burst)
+		rlt.buckets[host] = bucket
+	}
+	return bucket
+}
+
+// tokenBucket is a token bucket that refills at rate
+// tokens per second up to a capacity of burst tokens.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take tries to remove a token from the bucket. If none is available
+// it returns false and how long to wait until one becomes available.
+func (tb *tokenBucket) take(now time.Time) (ok bool, wait time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill(now)
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return true, 0
+	}
+	missing := 1 - tb.tokens
+	return false, time.Duration(missing / tb.rate * float64(time.Second))
+}
+
+// status returns the number of whole tokens left in the bucket
+// and how long until it is full again.
+func (tb *tokenBucket) status(now time.Time) (remaining int, full time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill(now)
+	missing := tb.burst - tb.tokens
+	return int(tb.tokens), time.Duration(missing / tb.rate * float64(time.Second))
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+	if !tb.last.IsZero() {
+		if elapsed := now.Sub(tb.last); elapsed > 0 {
+			tb.tokens += elapsed.Seconds() * tb.rate
+			if tb.tokens > tb.burst {
+				tb.tokens = tb.burst
+			}
+		}
+	}
+	tb.last = now
+}
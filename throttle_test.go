@@ -0,0 +1,76 @@
+package otils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Date(2021, time.January, 2, 15, 4, 5, 0, time.UTC)
+	tb := newTokenBucket(2, 2)
+
+	tests := []struct {
+		at       time.Duration
+		wantOK   bool
+		wantWait time.Duration
+	}{
+		0: {at: 0, wantOK: true},
+		1: {at: 0, wantOK: true},
+		2: {at: 0, wantOK: false, wantWait: 500 * time.Millisecond},
+		3: {at: 250 * time.Millisecond, wantOK: false, wantWait: 250 * time.Millisecond},
+		4: {at: 500 * time.Millisecond, wantOK: true},
+		5: {at: 10 * time.Second, wantOK: true},
+		6: {at: 10 * time.Second, wantOK: true},
+		7: {at: 10 * time.Second, wantOK: false, wantWait: 500 * time.Millisecond},
+	}
+
+	for i, tt := range tests {
+		ok, wait := tb.take(start.Add(tt.at))
+		if ok != tt.wantOK || wait != tt.wantWait {
+			t.Errorf("#%d got=(%t, %s) want=(%t, %s)", i, ok, wait, tt.wantOK, tt.wantWait)
+		}
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	tst := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer tst.Close()
+
+	client := &http.Client{Transport: &RateLimitTransport{RequestsPerSecond: 1, Burst: 2}}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(tst.URL)
+		if err != nil {
+			t.Fatalf("#%d: unexpected err: %v", i, err)
+		}
+		res.Body.Close()
+	}
+
+	// The burst is exhausted so the next request has to wait
+	// for about a second which is beyond its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	body := &closeTrackingBody{Reader: strings.NewReader("payload")}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, tst.URL, body)
+	if _, err := client.Transport.RoundTrip(req); err == nil {
+		t.Fatal("expected the rate limited request to fail")
+	}
+	if !body.closed {
+		t.Fatal("the body of the request that was not sent must be closed")
+	}
+}
+
+// closeTrackingBody records whether a request body was closed.
+type closeTrackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeTrackingBody) Close() error {
+	b.closed = true
+	return nil
+}
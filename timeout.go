@@ -0,0 +1,163 @@
+package otils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TimeoutRoute sets the deadline for the requests it matches.
+type TimeoutRoute struct {
+	Match RequestMatcher
+
+	// Timeout is the deadline for matching requests, zero or
+	// negative disables the deadline, e.g. for streaming routes.
+	Timeout time.Duration
+}
+
+// Timeout is a middleware that bounds how long handlers have to respond.
+// The deadline is set on the request's context so that the work the
+// handler does is cancelled too, and once it passes the client is sent a
+// *CodedError through WriteCodedError. Responses are buffered until the
+// handler returns; writes that it makes after the deadline fail with
+// http.ErrHandlerTimeout instead of reaching the client.
+// Sample usage is:
+//
+//	handler := otils.TimeoutMiddleware(&otils.Timeout{
+//		Default: 5 * time.Second,
+//		Routes: []*otils.TimeoutRoute{
+//			{Match: otils.MatchPathPrefix("/reports"), Timeout: time.Minute},
+//			{Match: otils.MatchPathPrefix("/events"), Timeout: 0},
+//		},
+//	}, mux)
+type Timeout struct {
+	// Default is the deadline of requests that match none of the Routes.
+	Default time.Duration
+
+	// Routes are tried in order, the first match setting the deadline.
+	Routes []*TimeoutRoute
+
+	// Code is the status code of timed out responses, it defaults to 503
+	// but 504 suits handlers that mostly wait on upstream services.
+	Code int
+
+	next http.Handler
+}
+
+// TimeoutMiddleware returns a handler that runs next with the deadlines of t.
+// A nil Timeout sets no deadlines.
+func TimeoutMiddleware(t *Timeout, next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	copy := new(Timeout)
+	*copy = *t
+	copy.next = next
+	if copy.Code == 0 {
+		copy.Code = http.StatusServiceUnavailable
+	}
+	return copy
+}
+
+var _ http.Handler = (*Timeout)(nil)
+
+func (t *Timeout) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if t.next == nil {
+		return
+	}
+	timeout := t.timeoutFor(req)
+	if timeout <= 0 {
+		t.next.ServeHTTP(rw, req)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	tw := &timeoutWriter{header: make(http.Header), status: http.StatusOK}
+	done := make(chan struct{})
+	panicc := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					p = fmt.Sprintf("%v\n%s", p, debug.Stack())
+				}
+				panicc <- p
+			}
+		}()
+		t.next.ServeHTTP(tw, req)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicc:
+		// Re-panic in the serving goroutine so that RecoverMiddleware
+		// and net/http see it.
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		dst := rw.Header()
+		for key, values := range tw.header {
+			dst[key] = values
+		}
+		rw.WriteHeader(tw.status)
+		_, _ = rw.Write(tw.body.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		tw.timedOut = true
+		if ctx.Err() == context.DeadlineExceeded {
+			WriteCodedError(rw, req, MakeCodedError("request timed out", t.Code))
+		}
+		// Otherwise the client went away and there is nobody to respond to.
+	}
+}
+
+func (t *Timeout) timeoutFor(req *http.Request) time.Duration {
+	for _, route := range t.Routes {
+		if route.Match == nil || route.Match(req) {
+			return route.Timeout
+		}
+	}
+	return t.Default
+}
+
+// timeoutWriter buffers a response so that it can be discarded if
+// the handler does not finish in time.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	timedOut    bool
+}
+
+func (w *timeoutWriter) Header() http.Header { return w.header }
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
@@ -0,0 +1,124 @@
+package otils
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	handler := func(rw http.ResponseWriter, req *http.Request) {
+		delay, _ := time.ParseDuration(req.URL.Query().Get("delay"))
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			if req.URL.Query().Get("write_late") != "" {
+				time.Sleep(10 * time.Millisecond)
+				_, err := io.WriteString(rw, "too late")
+				lateWrite <- err
+			}
+			return
+		}
+		rw.Header().Set("X-Handler", "1")
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(rw, "done")
+	}
+
+	to := &Timeout{
+		Default: 50 * time.Millisecond,
+		Routes: []*TimeoutRoute{
+			{Match: MatchPathPrefix("/slow"), Timeout: 200 * time.Millisecond},
+			{Match: MatchPathPrefix("/stream"), Timeout: 0},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		to       *Timeout
+		url      string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "within the default",
+			to:       to,
+			url:      "/fast?delay=1ms",
+			wantCode: http.StatusCreated,
+			wantBody: "done",
+		},
+		{
+			name:     "beyond the default",
+			to:       to,
+			url:      "/fast?delay=1s",
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "route with a longer deadline",
+			to:       to,
+			url:      "/slow?delay=100ms",
+			wantCode: http.StatusCreated,
+			wantBody: "done",
+		},
+		{
+			name:     "route without a deadline",
+			to:       to,
+			url:      "/stream?delay=100ms",
+			wantCode: http.StatusCreated,
+			wantBody: "done",
+		},
+		{
+			name:     "custom code",
+			to:       &Timeout{Default: 10 * time.Millisecond, Code: http.StatusGatewayTimeout},
+			url:      "/fast?delay=1s",
+			wantCode: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			TimeoutMiddleware(tt.to, http.HandlerFunc(handler)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("gotCode=%d wantCode=%d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" {
+				if rec.Body.String() != tt.wantBody || rec.Header().Get("X-Handler") != "1" {
+					t.Fatalf("got=(%q, %v) want=%q", rec.Body, rec.Header(), tt.wantBody)
+				}
+				return
+			}
+			var body codedErrorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode || body.Error != "request timed out" {
+				t.Fatalf("unexpected body %q: %v", rec.Body, err)
+			}
+		})
+	}
+
+	t.Run("late write", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		TimeoutMiddleware(&Timeout{Default: 10 * time.Millisecond}, http.HandlerFunc(handler)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?delay=1s&write_late=1", nil))
+		if err := <-lateWrite; err != http.ErrHandlerTimeout {
+			t.Fatalf("got=%v want=%v", err, http.ErrHandlerTimeout)
+		}
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("gotCode=%d wantCode=%d", rec.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func TestTimeoutMiddlewarePanics(t *testing.T) {
+	handler := TimeoutMiddleware(&Timeout{Default: time.Second}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	}))
+	handler = RecoverMiddleware(&Recoverer{OnPanic: func(*http.Request, interface{}, []byte) {}}, handler)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("gotCode=%d wantCode=%d", rec.Code, http.StatusInternalServerError)
+	}
+}